@@ -17,11 +17,16 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -32,31 +37,62 @@ import (
 type customSD struct {
 	Targets []string          `json:"targets"`
 	Labels  map[string]string `json:"labels"`
+	// job is the name of the discovery provider which generated the group.
+	job string
+	// source is the source of the target group.
+	source string
+}
+
+// seenTimes records when a target group was seen for the first and last time.
+type seenTimes struct {
+	first time.Time
+	last  time.Time
+}
+
+// Output persists the target groups generated by the Adapter.
+type Output interface {
+	Write(groups []customSD) error
+}
+
+// Listener is notified of the changes of the target groups.
+type Listener interface {
+	Notify(events []targetEvent)
 }
 
 // Adapter runs an unknown service discovery implementation and converts its target groups
-// to JSON and writes to a file for file_sd.
+// to JSON and writes them to the configured outputs.
 type Adapter struct {
-	ctx     context.Context
-	disc    discovery.Discoverer
-	groups  map[string]*customSD
-	manager *discovery.Manager
-	output  string
-	name    string
-	logger  log.Logger
+	ctx       context.Context
+	providers map[string]discovery.Discoverer
+	mtx       sync.RWMutex
+	groups    map[string]*customSD
+	seen      map[string]seenTimes // indexed by job and source
+	jobs      map[string]struct{}  // jobs which were part of a previous update
+	manager   *discovery.Manager
+	outputs   []Output
+	synced    bool // true when the last write to the outputs succeeded
+	status    *discoveryStatus
+	listeners []Listener
+	logger    log.Logger
 }
 
+// mapToArray returns the groups sorted by key so that the outputs are stable.
 func mapToArray(m map[string]*customSD) []customSD {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
 	arr := make([]customSD, 0, len(m))
-	for _, v := range m {
-		arr = append(arr, *v)
+	for _, k := range keys {
+		arr = append(arr, *m[k])
 	}
 	return arr
 }
 
-// Parses incoming target groups updates. If the update contains changes to the target groups
-// Adapter already knows about, or new target groups, we Marshal to JSON and write to file.
-func (a *Adapter) generateTargetGroups(allTargetGroups map[string][]*targetgroup.Group) {
+// Converts the target groups of all the discovery providers to customSD groups.
+func convertTargetGroups(allTargetGroups map[string][]*targetgroup.Group) map[string]*customSD {
 	tempGroups := make(map[string]*customSD)
 	for k, sdTargetGroups := range allTargetGroups {
 		for i, group := range sdTargetGroups {
@@ -77,25 +113,141 @@ func (a *Adapter) generateTargetGroups(allTargetGroups map[string][]*targetgroup
 			tempGroups[key] = &customSD{
 				Targets: newTargets,
 				Labels:  newLabels,
+				job:     k,
+				source:  group.Source,
 			}
 		}
 	}
-	if !reflect.DeepEqual(a.groups, tempGroups) {
+	return tempGroups
+}
+
+// Parses incoming target groups updates. If the update contains changes to the target groups
+// Adapter already knows about, or new target groups, we Marshal to JSON and write to the outputs.
+func (a *Adapter) generateTargetGroups(allTargetGroups map[string][]*targetgroup.Group) {
+	tempGroups := convertTargetGroups(allTargetGroups)
+	a.updateSeen(tempGroups)
+	// The outputs are also written when the previous write failed or never happened.
+	if !a.synced || !reflect.DeepEqual(a.groups, tempGroups) {
+		if events := diffGroups(a.groups, tempGroups, time.Now()); len(events) > 0 {
+			// The first groups of a job are the initial snapshot.
+			for i := range events {
+				if _, ok := a.jobs[events[i].Job]; !ok {
+					events[i].Initial = true
+				}
+			}
+			for _, l := range a.listeners {
+				l.Notify(events)
+			}
+		}
+		a.mtx.Lock()
 		a.groups = tempGroups
+		a.mtx.Unlock()
 		err := a.writeOutput()
+		a.synced = err == nil
+		if a.status != nil {
+			a.status.outputWritten(err)
+		}
 		if err != nil {
 			level.Error(log.With(a.logger, "component", "sd-adapter")).Log("err", err)
 		}
 	}
+	for job := range allTargetGroups {
+		a.jobs[job] = struct{}{}
+	}
+}
 
+// WriteOnce writes the target groups of a single discovery of every provider
+// to the outputs, even if they haven't changed.
+func (a *Adapter) WriteOnce(all map[string][]*targetgroup.Group) error {
+	a.mtx.Lock()
+	a.groups = convertTargetGroups(all)
+	a.mtx.Unlock()
+	return a.writeOutput()
 }
 
-// Writes JSON formatted targets to output file.
+// updateSeen records the current time as the last time the non-empty groups
+// were seen and forgets about the groups which disappeared.
+func (a *Adapter) updateSeen(groups map[string]*customSD) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	now := time.Now()
+	current := make(map[string]seenTimes, len(groups))
+	for _, g := range groups {
+		if len(g.Targets) == 0 {
+			continue
+		}
+		k := g.job + "/" + g.source
+		st, ok := a.seen[k]
+		if !ok {
+			st.first = now
+		}
+		st.last = now
+		current[k] = st
+	}
+	a.seen = current
+}
+
+// Seen returns when the target group was seen for the first and last time.
+func (a *Adapter) Seen(job, source string) (time.Time, time.Time) {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	st := a.seen[job+"/"+source]
+	return st.first, st.last
+}
+
+// SetStatus configures the Adapter to report the result of the writes to the given status.
+func (a *Adapter) SetStatus(s *discoveryStatus) {
+	a.status = s
+}
+
+// AddListener registers a listener notified of the changes of the target groups.
+func (a *Adapter) AddListener(l Listener) {
+	a.listeners = append(a.listeners, l)
+}
+
+// Groups returns the target groups currently known by the Adapter.
+func (a *Adapter) Groups() []customSD {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	return mapToArray(a.groups)
+}
+
+// Writes the current target groups to all the outputs. All the outputs are
+// tried even if one fails and the errors are returned together.
 func (a *Adapter) writeOutput() error {
-	arr := mapToArray(a.groups)
-	b, _ := json.MarshalIndent(arr, "", "    ")
+	arr := a.Groups()
+	var errs []string
+	for _, o := range a.outputs {
+		if err := o.Write(arr); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// fileOutput writes JSON formatted targets to a file_sd compatible file.
+type fileOutput struct {
+	path string
+}
 
-	dir, _ := filepath.Split(a.output)
+// Write implements the Output interface. The targets are printed to the
+// standard output if the path is "-".
+func (o *fileOutput) Write(groups []customSD) error {
+	b, _ := json.MarshalIndent(groups, "", "    ")
+	if o.path == "-" {
+		_, err := os.Stdout.Write(append(b, '\n'))
+		return err
+	}
+	return writeFileAtomic(o.path, b)
+}
+
+// writeFileAtomic replaces the content of the file with b by renaming a temporary file.
+func writeFileAtomic(path string, b []byte) error {
+	dir, _ := filepath.Split(path)
 	tmpfile, err := ioutil.TempFile(dir, "sd-adapter")
 	if err != nil {
 		return err
@@ -107,7 +259,7 @@ func (a *Adapter) writeOutput() error {
 		return err
 	}
 
-	err = os.Rename(tmpfile.Name(), a.output)
+	err = os.Rename(tmpfile.Name(), path)
 	if err != nil {
 		return err
 	}
@@ -130,22 +282,27 @@ func (a *Adapter) runCustomSD(ctx context.Context) {
 	}
 }
 
-// Run starts a Discovery Manager and the custom service discovery implementation.
+// Run starts a Discovery Manager and the custom service discovery implementations.
 func (a *Adapter) Run() {
 	go a.manager.Run()
-	a.manager.StartCustomProvider(a.ctx, a.name, a.disc)
+	for name, d := range a.providers {
+		a.manager.StartCustomProvider(a.ctx, name, d)
+	}
 	go a.runCustomSD(a.ctx)
 }
 
-// NewAdapter creates a new instance of Adapter.
-func NewAdapter(ctx context.Context, file string, name string, d discovery.Discoverer, logger log.Logger) *Adapter {
+// NewAdapter creates a new instance of Adapter running the given discovery
+// providers (indexed by name) and writing their target groups to the given
+// file and to the additional outputs.
+func NewAdapter(ctx context.Context, file string, providers map[string]discovery.Discoverer, logger log.Logger, outputs ...Output) *Adapter {
 	return &Adapter{
-		ctx:     ctx,
-		disc:    d,
-		groups:  make(map[string]*customSD),
-		manager: discovery.NewManager(ctx, logger),
-		output:  file,
-		name:    name,
-		logger:  logger,
+		ctx:       ctx,
+		providers: providers,
+		groups:    make(map[string]*customSD),
+		seen:      make(map[string]seenTimes),
+		jobs:      make(map[string]struct{}),
+		manager:   discovery.NewManager(ctx, logger),
+		outputs:   append([]Output{&fileOutput{path: file}}, outputs...),
+		logger:    logger,
 	}
 }
@@ -0,0 +1,86 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// apiResponse follows the format of the Prometheus HTTP API.
+type apiResponse struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data"`
+}
+
+// apiTarget is a target group returned by the targets API.
+type apiTarget struct {
+	Job       string            `json:"job"`
+	Source    string            `json:"source"`
+	Targets   []string          `json:"targets"`
+	Labels    map[string]string `json:"labels"`
+	FirstSeen time.Time         `json:"firstSeen"`
+	LastSeen  time.Time         `json:"lastSeen"`
+}
+
+func writeAPIResponse(w http.ResponseWriter, data interface{}, logger log.Logger) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(apiResponse{Status: "success", Data: data}); err != nil {
+		level.Error(logger).Log("msg", "failed to write API response", "err", err)
+	}
+}
+
+// apiTargetsHandler returns the target groups currently known by the Adapter.
+func apiTargetsHandler(a *Adapter, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		targets := make([]apiTarget, 0)
+		for _, g := range a.Groups() {
+			if len(g.Targets) == 0 {
+				continue
+			}
+			first, last := a.Seen(g.job, g.source)
+			targets = append(targets, apiTarget{
+				Job:       g.job,
+				Source:    g.source,
+				Targets:   g.Targets,
+				Labels:    g.Labels,
+				FirstSeen: first,
+				LastSeen:  last,
+			})
+		}
+		writeAPIResponse(w, targets, logger)
+	}
+}
+
+// apiHistoryHandler returns the recorded target events, oldest first. The
+// events can be filtered with the job, source and type query parameters.
+func apiHistoryHandler(h *eventHistory, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		events := make([]targetEvent, 0)
+		for _, e := range h.list() {
+			if (q.Get("job") != "" && q.Get("job") != e.Job) ||
+				(q.Get("source") != "" && q.Get("source") != e.Source) ||
+				(q.Get("type") != "" && q.Get("type") != e.Type) {
+				continue
+			}
+			events = append(events, e)
+		}
+		writeAPIResponse(w, events, logger)
+	}
+}
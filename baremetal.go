@@ -0,0 +1,206 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// baremetalOSRetryInterval is the delay before an OS which couldn't be
+// retrieved is requested again.
+const baremetalOSRetryInterval = 10 * time.Minute
+
+var (
+	// publicIPv6Label is the name for the label containing the server's public IPv6.
+	publicIPv6Label = scwPrefix + "public_ipv6"
+	// baremetalOfferIDLabel is the name for the label containing the ID of the Elastic Metal offer.
+	baremetalOfferIDLabel = scwPrefix + "baremetal_offer_id"
+	// baremetalOSVersionLabel is the name for the label containing the version of the installed OS.
+	baremetalOSVersionLabel = scwPrefix + "baremetal_os_version"
+)
+
+// scwBaremetalServer is a server returned by the Elastic Metal API.
+type scwBaremetalServer struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Status       string   `json:"status"`
+	OfferID      string   `json:"offer_id"`
+	OfferName    string   `json:"offer_name"`
+	Tags         []string `json:"tags"`
+	Organization string   `json:"organization_id"`
+	Zone         string   `json:"zone"`
+	IPs          []struct {
+		Address string `json:"address"`
+		Version string `json:"version"`
+	} `json:"ips"`
+	Install *struct {
+		OSID string `json:"os_id"`
+	} `json:"install"`
+}
+
+// scwBaremetalOS is an OS which can be installed on Elastic Metal servers.
+type scwBaremetalOS struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// baremetalRole discovers the Elastic Metal servers. The labels follow the
+// ones of the server role so that both can be scraped by the same job.
+type baremetalRole struct {
+	client    *scwAPIClient
+	zones     []string
+	port      int
+	separator string
+	logger    log.Logger
+
+	// mtx protects oses which caches the installable OSes by zone and ID and
+	// failures which caches the time of the failed lookups.
+	mtx      sync.Mutex
+	oses     map[string]*scwBaremetalOS
+	failures map[string]time.Time
+}
+
+func (r *baremetalRole) getServers(zone string) ([]scwBaremetalServer, error) {
+	var servers []scwBaremetalServer
+	err := r.client.list(fmt.Sprintf("/baremetal/v1/zones/%s/servers", zone), func(b json.RawMessage) (int, int, error) {
+		var resp struct {
+			Servers    []scwBaremetalServer `json:"servers"`
+			TotalCount int                  `json:"total_count"`
+		}
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return 0, 0, err
+		}
+		servers = append(servers, resp.Servers...)
+		return len(resp.Servers), resp.TotalCount, nil
+	})
+	return servers, err
+}
+
+// getOS returns the installable OS with the given ID. OSes don't change so
+// they are cached forever. The failed lookups are cached for
+// baremetalOSRetryInterval: it returns nil without error if a recent lookup
+// failed.
+func (r *baremetalRole) getOS(zone, id string) (*scwBaremetalOS, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	key := zone + "/" + id
+	if o, ok := r.oses[key]; ok {
+		return o, nil
+	}
+	if t, ok := r.failures[key]; ok && time.Since(t) < baremetalOSRetryInterval {
+		return nil, nil
+	}
+	if r.oses == nil {
+		r.oses = make(map[string]*scwBaremetalOS)
+		r.failures = make(map[string]time.Time)
+	}
+	o := &scwBaremetalOS{}
+	if err := r.client.get(fmt.Sprintf("/baremetal/v1/zones/%s/os/%s", zone, id), nil, o); err != nil {
+		r.failures[key] = time.Now()
+		return nil, err
+	}
+	delete(r.failures, key)
+	r.oses[key] = o
+	return o, nil
+}
+
+func (r *baremetalRole) createTarget(srv *scwBaremetalServer) *targetgroup.Group {
+	var ipv4, ipv6 string
+	for _, ip := range srv.IPs {
+		switch {
+		case ip.Version == "IPv4" && ipv4 == "":
+			ipv4 = ip.Address
+		case ip.Version == "IPv6" && ipv6 == "":
+			ipv6 = ip.Address
+		}
+	}
+
+	var osID, osName, osVersion string
+	if srv.Install != nil && srv.Install.OSID != "" {
+		osID = srv.Install.OSID
+		o, err := r.getOS(srv.Zone, osID)
+		if err != nil {
+			level.Warn(r.logger).Log("msg", "failed to get the OS", "os", osID, "err", err)
+		} else if o != nil {
+			osName, osVersion = o.Name, o.Version
+		}
+	}
+
+	labels := model.LabelSet{
+		model.LabelName(commercialTypeLabel):     model.LabelValue(srv.OfferName),
+		model.LabelName(identifierLabel):         model.LabelValue(srv.ID),
+		model.LabelName(imageIDLabel):            model.LabelValue(osID),
+		model.LabelName(imageNameLabel):          model.LabelValue(osName),
+		model.LabelName(nameLabel):               model.LabelValue(srv.Name),
+		model.LabelName(orgLabel):                model.LabelValue(srv.Organization),
+		model.LabelName(privateIPLabel):          "",
+		model.LabelName(publicIPLabel):           model.LabelValue(ipv4),
+		model.LabelName(publicIPv6Label):         model.LabelValue(ipv6),
+		model.LabelName(stateLabel):              model.LabelValue(srv.Status),
+		model.LabelName(tagsLabel):               model.LabelValue(joinTags(srv.Tags, r.separator)),
+		model.LabelName(zoneLabel):               model.LabelValue(srv.Zone),
+		model.LabelName(baremetalOfferIDLabel):   model.LabelValue(srv.OfferID),
+		model.LabelName(baremetalOSVersionLabel): model.LabelValue(osVersion),
+	}
+
+	addr := ipv4
+	if addr == "" {
+		addr = ipv6
+	}
+	var targets []model.LabelSet
+	if addr != "" {
+		addr = net.JoinHostPort(addr, strconv.Itoa(r.port))
+		labels[model.AddressLabel] = model.LabelValue(addr)
+		targets = append(targets, model.LabelSet{
+			model.AddressLabel: model.LabelValue(addr),
+		})
+	}
+
+	return &targetgroup.Group{
+		Source:  fmt.Sprintf("scaleway/baremetal/%s/%s", srv.Zone, srv.ID),
+		Targets: targets,
+		Labels:  labels,
+	}
+}
+
+func (r *baremetalRole) targets() ([]*targetgroup.Group, error) {
+	var tgs []*targetgroup.Group
+	for _, zone := range r.zones {
+		servers, err := r.getServers(zone)
+		if err != nil {
+			return nil, err
+		}
+		level.Debug(r.logger).Log("msg", "get Elastic Metal servers", "zone", zone, "nb", len(servers))
+
+		for i := range servers {
+			if servers[i].Zone == "" {
+				servers[i].Zone = zone
+			}
+			tgs = append(tgs, r.createTarget(&servers[i]))
+		}
+	}
+	return tgs, nil
+}
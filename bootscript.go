@@ -0,0 +1,107 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/scaleway/go-scaleway"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+// bootscriptRetryInterval is the delay before a bootscript which couldn't be
+// retrieved is requested again.
+const bootscriptRetryInterval = 10 * time.Minute
+
+// kernelVersionRe matches a kernel version like 4.4.127.
+var kernelVersionRe = regexp.MustCompile(`\d+\.\d+(\.\d+)?`)
+
+// bootscriptCache caches the bootscripts retrieved from the Scaleway API.
+// Bootscripts don't change once published so they are kept forever. The
+// failed lookups (for instance deleted bootscripts) are cached for
+// bootscriptRetryInterval.
+type bootscriptCache struct {
+	client *api.ScalewayAPI
+
+	mtx         sync.Mutex
+	bootscripts map[string]*types.ScalewayBootscript
+	failures    map[string]time.Time
+}
+
+func newBootscriptCache(client *api.ScalewayAPI) *bootscriptCache {
+	return &bootscriptCache{
+		client:      client,
+		bootscripts: make(map[string]*types.ScalewayBootscript),
+		failures:    make(map[string]time.Time),
+	}
+}
+
+// get returns the bootscript with the given identifier. It returns nil
+// without error if a recent lookup failed.
+func (c *bootscriptCache) get(id string) (*types.ScalewayBootscript, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if b, ok := c.bootscripts[id]; ok {
+		return b, nil
+	}
+	if t, ok := c.failures[id]; ok && time.Since(t) < bootscriptRetryInterval {
+		return nil, nil
+	}
+	b, err := c.client.GetBootscript(id)
+	if err != nil {
+		c.failures[id] = time.Now()
+		return nil, err
+	}
+	delete(c.failures, id)
+	c.bootscripts[id] = b
+	return b, nil
+}
+
+// resolve returns the bootscript of the server, completed from the Scaleway
+// API when the server only references it by identifier. The reference is
+// returned as is while the lookup of the bootscript is failing.
+func (c *bootscriptCache) resolve(b *types.ScalewayBootscript) (*types.ScalewayBootscript, error) {
+	if b == nil || b.Identifier == "" || b.Title != "" || b.Kernel != "" {
+		return b, nil
+	}
+	full, err := c.get(b.Identifier)
+	if full == nil {
+		return b, err
+	}
+	return full, nil
+}
+
+// kernelVersion returns the version of the kernel of a bootscript. The kernel
+// field is the download URL of the kernel (eg
+// http://169.254.42.24/kernel/x86_64-mainline-lts-4.4-4.4.127-rev1/vmlinuz-4.4.127)
+// so the version is searched in the file name, then in the rest of the URL
+// and finally in the title of the bootscript.
+func kernelVersion(kernel, title string) string {
+	p := kernel
+	if u, err := url.Parse(kernel); err == nil {
+		p = u.Path
+	}
+	if v := kernelVersionRe.FindString(path.Base(p)); v != "" {
+		return v
+	}
+	if vs := kernelVersionRe.FindAllString(path.Dir(p), -1); len(vs) > 0 {
+		return vs[len(vs)-1]
+	}
+	return kernelVersionRe.FindString(title)
+}
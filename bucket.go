@@ -0,0 +1,98 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/scaleway/go-scaleway"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+var (
+	// bucketNameLabel is the name for the label containing the name of the bucket.
+	bucketNameLabel = scwPrefix + "bucket_name"
+	// bucketRegionLabel is the name for the label containing the object storage region of the bucket.
+	bucketRegionLabel = scwPrefix + "bucket_region"
+	// bucketSizeLabel is the name for the label containing the size of the bucket as returned by the API.
+	bucketSizeLabel = scwPrefix + "bucket_size"
+	// bucketEndpointLabel is the name for the label containing the public endpoint URL of the bucket.
+	bucketEndpointLabel = scwPrefix + "bucket_endpoint"
+
+	// objectStorageRegions maps the compute regions to the object storage regions.
+	objectStorageRegions = map[string]string{
+		"":     "fr-par",
+		"par1": "fr-par",
+		"ams1": "nl-ams",
+	}
+)
+
+// bucketRole discovers the object storage buckets. The targets are the
+// public endpoint URLs of the buckets which suit blackbox probing.
+type bucketRole struct {
+	client *api.ScalewayAPI
+	logger log.Logger
+}
+
+// bucketEndpoint returns the public URL of the bucket. Bucket names with dots
+// use the path-style URL because they don't match the wildcard certificate.
+func bucketEndpoint(name, region string) string {
+	if strings.Contains(name, ".") {
+		return fmt.Sprintf("https://s3.%s.scw.cloud/%s", region, name)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.scw.cloud", name, region)
+}
+
+func (r *bucketRole) createTarget(c *types.ScalewayContainer) *targetgroup.Group {
+	region := objectStorageRegions[r.client.Region]
+	endpoint := bucketEndpoint(c.Name, region)
+
+	return &targetgroup.Group{
+		Source: fmt.Sprintf("scaleway/bucket/%s/%s", region, c.Name),
+		Targets: []model.LabelSet{
+			model.LabelSet{
+				model.AddressLabel: model.LabelValue(endpoint),
+			},
+		},
+		Labels: model.LabelSet{
+			model.AddressLabel:                   model.LabelValue(endpoint),
+			model.LabelName(identifierLabel):     model.LabelValue(c.Name),
+			model.LabelName(orgLabel):            model.LabelValue(c.ScalewayOrganizationDefinition.ID),
+			model.LabelName(bucketNameLabel):     model.LabelValue(c.Name),
+			model.LabelName(bucketRegionLabel):   model.LabelValue(region),
+			model.LabelName(bucketSizeLabel):     model.LabelValue(c.Size),
+			model.LabelName(bucketEndpointLabel): model.LabelValue(endpoint),
+		},
+	}
+}
+
+func (r *bucketRole) targets() ([]*targetgroup.Group, error) {
+	containers, err := r.client.GetContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	level.Debug(r.logger).Log("msg", "get buckets", "nb", len(containers.Containers))
+
+	tgs := make([]*targetgroup.Group, 0, len(containers.Containers))
+	for _, c := range containers.Containers {
+		tgs = append(tgs, r.createTarget(&c))
+	}
+	return tgs, nil
+}
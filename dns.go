@@ -0,0 +1,215 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/miekg/dns"
+)
+
+// dnsServer answers A, AAAA and SRV queries from the targets currently known
+// by the Adapter.
+//
+// Under the configured domain, names are of the form [<host>.][<role>.]<domain>
+// where the role (or the job name of the role) selects the targets of a
+// single role, the server role being the default. A host matches the
+// targets which have the same name, identifier or tag. SRV queries are
+// expected to be of the form _<service>._<proto>.[<host>.][<role>.]<domain>
+// and return all the targets of the role when the host is omitted. The port
+// of the SRV records comes from the services mapping or, if the service isn't
+// listed, from the target's address.
+type dnsServer struct {
+	adapter   *Adapter
+	domain    string
+	roles     []string
+	ttl       uint32
+	services  map[string]string
+	separator string
+	logger    log.Logger
+}
+
+func newDNSServer(a *Adapter, domain string, roles []string, ttl time.Duration, services map[string]string, separator string, logger log.Logger) *dnsServer {
+	return &dnsServer{
+		adapter:   a,
+		domain:    dns.Fqdn(strings.ToLower(domain)),
+		roles:     roles,
+		ttl:       uint32(ttl.Seconds()),
+		services:  services,
+		separator: separator,
+		logger:    log.With(logger, "component", "dns"),
+	}
+}
+
+// dnsTarget is a target resolved from the current target groups.
+type dnsTarget struct {
+	id   string
+	ip   net.IP
+	port string
+}
+
+// ListenAndServe starts the UDP and TCP listeners and blocks until one of them fails.
+func (s *dnsServer) ListenAndServe(addr string) error {
+	errc := make(chan error, 2)
+	for _, n := range []string{"udp", "tcp"} {
+		srv := &dns.Server{Addr: addr, Net: n, Handler: s}
+		go func() {
+			errc <- srv.ListenAndServe()
+		}()
+	}
+	return <-errc
+}
+
+// ServeDNS implements the dns.Handler interface.
+func (s *dnsServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	for _, q := range r.Question {
+		name := strings.ToLower(q.Name)
+		if !dns.IsSubDomain(s.domain, name) {
+			m.SetRcode(r, dns.RcodeRefused)
+			break
+		}
+		labels, role := s.splitRole(dns.SplitDomainName(strings.TrimSuffix(name, s.domain)))
+
+		switch q.Qtype {
+		case dns.TypeA, dns.TypeAAAA:
+			if len(labels) != 1 {
+				m.SetRcode(r, dns.RcodeNameError)
+				continue
+			}
+			for _, t := range s.lookup(labels[0], role) {
+				if rr := s.addressRecord(q.Name, q.Qtype, t.ip); rr != nil {
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+		case dns.TypeSRV:
+			if len(labels) < 2 || len(labels) > 3 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+				m.SetRcode(r, dns.RcodeNameError)
+				continue
+			}
+			var host string
+			if len(labels) == 3 {
+				host = labels[2]
+			}
+			port, svcOK := s.services[strings.TrimPrefix(labels[0], "_")]
+			for _, t := range s.lookup(host, role) {
+				if !svcOK {
+					port = t.port
+				}
+				p, err := strconv.ParseUint(port, 10, 16)
+				if err != nil {
+					continue
+				}
+				// The target name must resolve to the same role.
+				target := t.id + "." + s.domain
+				if role != "server" {
+					target = t.id + "." + role + "." + s.domain
+				}
+				m.Answer = append(m.Answer, &dns.SRV{
+					Hdr:      dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: s.ttl},
+					Priority: 10,
+					Weight:   10,
+					Port:     uint16(p),
+					Target:   target,
+				})
+				if rr := s.addressRecord(target, dns.TypeA, t.ip); rr != nil {
+					m.Extra = append(m.Extra, rr)
+				}
+				if rr := s.addressRecord(target, dns.TypeAAAA, t.ip); rr != nil {
+					m.Extra = append(m.Extra, rr)
+				}
+			}
+		}
+	}
+
+	if err := w.WriteMsg(m); err != nil {
+		level.Error(s.logger).Log("msg", "failed to write DNS response", "err", err)
+	}
+}
+
+// addressRecord returns an A or AAAA record depending on the query type and
+// nil if the IP doesn't belong to the requested family.
+func (s *dnsServer) addressRecord(name string, qtype uint16, ip net.IP) dns.RR {
+	hdr := dns.RR_Header{Name: name, Rrtype: qtype, Class: dns.ClassINET, Ttl: s.ttl}
+	ip4 := ip.To4()
+	switch {
+	case qtype == dns.TypeA && ip4 != nil:
+		return &dns.A{Hdr: hdr, A: ip4}
+	case qtype == dns.TypeAAAA && ip4 == nil:
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}
+	}
+	return nil
+}
+
+// splitRole removes the role from the labels of a name and returns it. The
+// role is the last label if it is the name or the job name of an enabled
+// role, the server role otherwise.
+func (s *dnsServer) splitRole(labels []string) ([]string, string) {
+	if len(labels) > 0 {
+		last := labels[len(labels)-1]
+		for _, r := range s.roles {
+			if last == r || strings.EqualFold(last, roleJob(r)) {
+				return labels[:len(labels)-1], r
+			}
+		}
+	}
+	return labels, "server"
+}
+
+// lookup returns the targets of the role matching the given host. An empty
+// host matches all the targets of the role.
+func (s *dnsServer) lookup(host, role string) []dnsTarget {
+	var targets []dnsTarget
+	for _, g := range s.adapter.Groups() {
+		if g.Labels[roleLabel] != role {
+			continue
+		}
+		if host != "" && !s.match(g.Labels, host) {
+			continue
+		}
+		for _, addr := range g.Targets {
+			h, p, err := net.SplitHostPort(addr)
+			if err != nil {
+				continue
+			}
+			ip := net.ParseIP(h)
+			id := g.Labels[identifierLabel]
+			if ip == nil || id == "" {
+				continue
+			}
+			targets = append(targets, dnsTarget{
+				id:   strings.ToLower(id),
+				ip:   ip,
+				port: p,
+			})
+		}
+	}
+	return targets
+}
+
+func (s *dnsServer) match(labels map[string]string, host string) bool {
+	if strings.EqualFold(labels[identifierLabel], host) || strings.EqualFold(labels[nameLabel], host) {
+		return true
+	}
+	tags := strings.ToLower(labels[tagsLabel])
+	return strings.Contains(tags, s.separator+host+s.separator)
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	serverInfoDesc = prometheus.NewDesc(
+		"scaleway_server_info",
+		"Information about the Scaleway server.",
+		[]string{"id", "name", "commercial_type", "arch", "zone", "image", "image_id", "state", "organization", "private_ip", "public_ip", "security_group"},
+		nil,
+	)
+	serverCreatedDesc = prometheus.NewDesc(
+		"scaleway_server_created_timestamp_seconds",
+		"Creation time of the Scaleway server since unix epoch in seconds.",
+		[]string{"id"},
+		nil,
+	)
+	serverTagsDesc = prometheus.NewDesc(
+		"scaleway_server_tags",
+		"Tags of the Scaleway server, one series per tag.",
+		[]string{"id", "tag"},
+		nil,
+	)
+	serversDesc = prometheus.NewDesc(
+		"scaleway_servers",
+		"Number of Scaleway servers returned by the last successful request.",
+		nil,
+		nil,
+	)
+)
+
+// inventoryCollector exposes the servers cached by the server role as metrics.
+// It doesn't query the Scaleway API at scrape time.
+type inventoryCollector struct {
+	r *serverRole
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serverInfoDesc
+	ch <- serverCreatedDesc
+	ch <- serverTagsDesc
+	ch <- serversDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	srvs := c.r.cachedServers()
+	ch <- prometheus.MustNewConstMetric(serversDesc, prometheus.GaugeValue, float64(len(srvs)))
+
+	for _, s := range srvs {
+		ch <- prometheus.MustNewConstMetric(
+			serverInfoDesc,
+			prometheus.GaugeValue,
+			1,
+			s.Identifier,
+			s.Name,
+			s.CommercialType,
+			s.Arch,
+			s.Location.ZoneID,
+			s.Image.Name,
+			s.Image.Identifier,
+			s.State,
+			s.Organization,
+			s.PrivateIP,
+			s.PublicAddress.IP,
+			s.SecurityGroup.Name,
+		)
+		if t, err := time.Parse(time.RFC3339, s.CreationDate); err == nil {
+			ch <- prometheus.MustNewConstMetric(serverCreatedDesc, prometheus.GaugeValue, float64(t.UnixNano())/1e9, s.Identifier)
+		}
+		seen := make(map[string]struct{}, len(s.Tags))
+		for _, tag := range s.Tags {
+			// Duplicated tags would produce duplicated series.
+			if _, ok := seen[tag]; ok {
+				continue
+			}
+			seen[tag] = struct{}{}
+			ch <- prometheus.MustNewConstMetric(serverTagsDesc, prometheus.GaugeValue, 1, s.Identifier, tag)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// discoveryStatus tracks the state of the discovery loops and of the outputs
+// for the health and readiness endpoints.
+//
+// It is ready once every job has refreshed successfully and the outputs have
+// been written. It stops being ready when a job hasn't refreshed successfully
+// for more than tolerance intervals or when writing the outputs fails.
+type discoveryStatus struct {
+	mtx         sync.RWMutex
+	interval    time.Duration
+	tolerance   int
+	lastSuccess map[string]time.Time
+	written     bool
+	writeErr    error
+}
+
+func newDiscoveryStatus(interval time.Duration, tolerance int) *discoveryStatus {
+	return &discoveryStatus{
+		interval:    interval,
+		tolerance:   tolerance,
+		lastSuccess: make(map[string]time.Time),
+	}
+}
+
+// register declares a job which needs to refresh successfully before being ready.
+func (s *discoveryStatus) register(job string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := s.lastSuccess[job]; !ok {
+		s.lastSuccess[job] = time.Time{}
+	}
+}
+
+// refreshed records the result of a refresh of the given job.
+func (s *discoveryStatus) refreshed(job string, err error) {
+	if err != nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.lastSuccess[job] = time.Now()
+}
+
+// outputWritten records the result of writing the outputs.
+func (s *discoveryStatus) outputWritten(err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.writeErr = err
+	if err == nil {
+		s.written = true
+	}
+}
+
+// ready returns nil if the discovery is ready, otherwise the reason why it isn't.
+func (s *discoveryStatus) ready() error {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	jobs := make([]string, 0, len(s.lastSuccess))
+	for job := range s.lastSuccess {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+
+	for _, job := range jobs {
+		last := s.lastSuccess[job]
+		if last.IsZero() {
+			return fmt.Errorf("%s: waiting for the first successful refresh", job)
+		}
+		if since := time.Since(last); since > time.Duration(s.tolerance+1)*s.interval {
+			return fmt.Errorf("%s: no successful refresh for %s", job, since)
+		}
+	}
+	if s.writeErr != nil {
+		return fmt.Errorf("failed to write the targets: %v", s.writeErr)
+	}
+	if !s.written {
+		return fmt.Errorf("waiting for the targets to be written")
+	}
+	return nil
+}
+
+// healthyHandler always reports the service as healthy.
+func (s *discoveryStatus) healthyHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Healthy.")
+}
+
+// readyHandler reports whether the discovery is ready.
+func (s *discoveryStatus) readyHandler(w http.ResponseWriter, _ *http.Request) {
+	if err := s.ready(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "Not ready: %v.\n", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Ready.")
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Types of target events.
+const (
+	eventAdded   = "added"
+	eventRemoved = "removed"
+	eventChanged = "changed"
+)
+
+// labelChange is the old and new values of a label which changed.
+type labelChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// targetEvent describes a change of a target group between two updates.
+type targetEvent struct {
+	Time    time.Time              `json:"time"`
+	Type    string                 `json:"type"`
+	Job     string                 `json:"job"`
+	Source  string                 `json:"source"`
+	Targets []string               `json:"targets"`
+	Labels  map[string]string      `json:"labels"`
+	Diff    map[string]labelChange `json:"diff,omitempty"`
+	// PreviousTargets is set for the changed groups whose targets changed.
+	PreviousTargets []string `json:"previous_targets,omitempty"`
+	// Initial is true for the groups of the first update of a job after a
+	// start which aren't actual changes.
+	Initial bool `json:"initial,omitempty"`
+}
+
+// diffGroups returns the events needed to go from the previous target groups
+// to the current ones. Groups without targets are considered as absent.
+func diffGroups(previous, current map[string]*customSD, now time.Time) []targetEvent {
+	index := func(groups map[string]*customSD) map[string]*customSD {
+		m := make(map[string]*customSD, len(groups))
+		for _, g := range groups {
+			if len(g.Targets) > 0 {
+				m[g.job+"/"+g.source] = g
+			}
+		}
+		return m
+	}
+	before, after := index(previous), index(current)
+
+	var events []targetEvent
+	for k, g := range after {
+		prev, ok := before[k]
+		if !ok {
+			events = append(events, targetEvent{Time: now, Type: eventAdded, Job: g.job, Source: g.source, Targets: g.Targets, Labels: g.Labels})
+			continue
+		}
+		diff := make(map[string]labelChange)
+		for name, v := range g.Labels {
+			if pv := prev.Labels[name]; pv != v {
+				diff[name] = labelChange{Old: pv, New: v}
+			}
+		}
+		for name, pv := range prev.Labels {
+			if _, ok := g.Labels[name]; !ok {
+				diff[name] = labelChange{Old: pv}
+			}
+		}
+		targetsChanged := !equalStrings(prev.Targets, g.Targets)
+		if len(diff) > 0 || targetsChanged {
+			e := targetEvent{Time: now, Type: eventChanged, Job: g.job, Source: g.source, Targets: g.Targets, Labels: g.Labels}
+			if len(diff) > 0 {
+				e.Diff = diff
+			}
+			if targetsChanged {
+				e.PreviousTargets = prev.Targets
+			}
+			events = append(events, e)
+		}
+	}
+	for k, g := range before {
+		if _, ok := after[k]; !ok {
+			events = append(events, targetEvent{Time: now, Type: eventRemoved, Job: g.job, Source: g.source, Targets: g.Targets, Labels: g.Labels})
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Job != events[j].Job {
+			return events[i].Job < events[j].Job
+		}
+		return events[i].Source < events[j].Source
+	})
+	return events
+}
+
+// equalStrings returns true if both slices have the same elements in the
+// same order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// eventHistory keeps the most recent target events in memory.
+type eventHistory struct {
+	mtx    sync.RWMutex
+	size   int
+	events []targetEvent
+}
+
+func newEventHistory(size int) *eventHistory {
+	return &eventHistory{size: size}
+}
+
+// Notify implements the Listener interface. It records the events, dropping
+// the oldest ones if the history is full.
+func (h *eventHistory) Notify(events []targetEvent) {
+	if h.size <= 0 {
+		return
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.events = append(h.events, events...)
+	if n := len(h.events) - h.size; n > 0 {
+		h.events = append([]targetEvent(nil), h.events[n:]...)
+	}
+}
+
+// list returns the recorded events, oldest first.
+func (h *eventHistory) list() []targetEvent {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	return append([]targetEvent(nil), h.events...)
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffGroups(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	group := func(job, source string, targets []string, labels map[string]string) *customSD {
+		return &customSD{Targets: targets, Labels: labels, job: job, source: source}
+	}
+	running := map[string]string{"__meta_scaleway_state": "running", "__meta_scaleway_name": "web1"}
+
+	for _, tc := range []struct {
+		name     string
+		previous map[string]*customSD
+		current  map[string]*customSD
+		want     []targetEvent
+	}{
+		{
+			name: "no change",
+			previous: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:80"}, running),
+			},
+			current: map[string]*customSD{
+				// The keys of the groups don't matter.
+				"b": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:80"}, running),
+			},
+		},
+		{
+			name:     "added",
+			previous: map[string]*customSD{},
+			current: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:80"}, running),
+			},
+			want: []targetEvent{
+				{Time: now, Type: eventAdded, Job: "scalewaySD", Source: "scaleway/1", Targets: []string{"10.0.0.1:80"}, Labels: running},
+			},
+		},
+		{
+			name: "removed",
+			previous: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:80"}, running),
+			},
+			current: map[string]*customSD{},
+			want: []targetEvent{
+				{Time: now, Type: eventRemoved, Job: "scalewaySD", Source: "scaleway/1", Targets: []string{"10.0.0.1:80"}, Labels: running},
+			},
+		},
+		{
+			name: "emptied group is removed",
+			previous: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:80"}, running),
+			},
+			current: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{}, nil),
+			},
+			want: []targetEvent{
+				{Time: now, Type: eventRemoved, Job: "scalewaySD", Source: "scaleway/1", Targets: []string{"10.0.0.1:80"}, Labels: running},
+			},
+		},
+		{
+			name: "label changed",
+			previous: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:80"}, running),
+			},
+			current: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:80"}, map[string]string{"__meta_scaleway_state": "stopping", "__meta_scaleway_name": "web1"}),
+			},
+			want: []targetEvent{
+				{
+					Time: now, Type: eventChanged, Job: "scalewaySD", Source: "scaleway/1", Targets: []string{"10.0.0.1:80"},
+					Labels: map[string]string{"__meta_scaleway_state": "stopping", "__meta_scaleway_name": "web1"},
+					Diff:   map[string]labelChange{"__meta_scaleway_state": {Old: "running", New: "stopping"}},
+				},
+			},
+		},
+		{
+			name: "label added and removed",
+			previous: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:80"}, running),
+			},
+			current: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:80"}, map[string]string{"__meta_scaleway_state": "running", "__meta_scaleway_tags": ",web,"}),
+			},
+			want: []targetEvent{
+				{
+					Time: now, Type: eventChanged, Job: "scalewaySD", Source: "scaleway/1", Targets: []string{"10.0.0.1:80"},
+					Labels: map[string]string{"__meta_scaleway_state": "running", "__meta_scaleway_tags": ",web,"},
+					Diff: map[string]labelChange{
+						"__meta_scaleway_name": {Old: "web1"},
+						"__meta_scaleway_tags": {New: ",web,"},
+					},
+				},
+			},
+		},
+		{
+			name: "targets changed",
+			previous: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:9100", "10.0.0.1:9256"}, running),
+			},
+			current: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:9100"}, running),
+			},
+			want: []targetEvent{
+				{
+					Time: now, Type: eventChanged, Job: "scalewaySD", Source: "scaleway/1", Targets: []string{"10.0.0.1:9100"}, Labels: running,
+					PreviousTargets: []string{"10.0.0.1:9100", "10.0.0.1:9256"},
+				},
+			},
+		},
+		{
+			name: "targets and label changed",
+			previous: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:9100"}, map[string]string{"__meta_scaleway_blocked_ports": ",9256,"}),
+			},
+			current: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:9100", "10.0.0.1:9256"}, map[string]string{"__meta_scaleway_blocked_ports": ""}),
+			},
+			want: []targetEvent{
+				{
+					Time: now, Type: eventChanged, Job: "scalewaySD", Source: "scaleway/1", Targets: []string{"10.0.0.1:9100", "10.0.0.1:9256"},
+					Labels:          map[string]string{"__meta_scaleway_blocked_ports": ""},
+					Diff:            map[string]labelChange{"__meta_scaleway_blocked_ports": {Old: ",9256,", New: ""}},
+					PreviousTargets: []string{"10.0.0.1:9100"},
+				},
+			},
+		},
+		{
+			name: "same source in different jobs",
+			previous: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:80"}, running),
+			},
+			current: map[string]*customSD{
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:80"}, running),
+				"b": group("scalewaySD_lb", "scaleway/1", []string{"10.0.0.2:443"}, nil),
+			},
+			want: []targetEvent{
+				{Time: now, Type: eventAdded, Job: "scalewaySD_lb", Source: "scaleway/1", Targets: []string{"10.0.0.2:443"}},
+			},
+		},
+		{
+			name: "events sorted by job and source",
+			previous: map[string]*customSD{
+				"c": group("scalewaySD", "scaleway/3", []string{"10.0.0.3:80"}, nil),
+			},
+			current: map[string]*customSD{
+				"b": group("scalewaySD_rdb", "scaleway/rdb/1", []string{"10.0.0.4:5432"}, nil),
+				"a": group("scalewaySD", "scaleway/1", []string{"10.0.0.1:80"}, nil),
+			},
+			want: []targetEvent{
+				{Time: now, Type: eventAdded, Job: "scalewaySD", Source: "scaleway/1", Targets: []string{"10.0.0.1:80"}},
+				{Time: now, Type: eventRemoved, Job: "scalewaySD", Source: "scaleway/3", Targets: []string{"10.0.0.3:80"}},
+				{Time: now, Type: eventAdded, Job: "scalewaySD_rdb", Source: "scaleway/rdb/1", Targets: []string{"10.0.0.4:5432"}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := diffGroups(tc.previous, tc.current, now)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var invalidGroupChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// ansibleGroup is a group of the Ansible dynamic inventory.
+type ansibleGroup struct {
+	Hosts    []string `json:"hosts,omitempty"`
+	Children []string `json:"children,omitempty"`
+}
+
+// ansibleInventory builds the Ansible dynamic inventory from the same servers
+// and labels as the file_sd targets. Hosts are named after the servers (or
+// their identifier if the name isn't unique) and their variables are the
+// meta labels without the __meta_scaleway_ prefix. Groups are derived from
+// the tags, zones, commercial types and security groups.
+func (r *serverRole) ansibleInventory() (map[string]interface{}, map[string]map[string]string, error) {
+	srvs, err := r.getServers()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make(map[string]int)
+	for _, s := range srvs {
+		names[s.Name]++
+	}
+
+	hostvars := make(map[string]map[string]string)
+	groups := make(map[string]*ansibleGroup)
+	addToGroup := func(prefix, value, host string) {
+		if value == "" {
+			return
+		}
+		name := prefix + "_" + invalidGroupChars.ReplaceAllString(value, "_")
+		if _, ok := groups[name]; !ok {
+			groups[name] = &ansibleGroup{}
+		}
+		groups[name].Hosts = append(groups[name].Hosts, host)
+	}
+
+	for _, s := range srvs {
+		host := s.Name
+		if names[s.Name] > 1 {
+			host = s.Identifier
+		}
+
+		tg := r.createTarget(&s)
+		vars := map[string]string{"ansible_host": s.PrivateIP}
+		for k, v := range tg.Labels {
+			if !strings.HasPrefix(string(k), scwPrefix) {
+				continue
+			}
+			vars[strings.TrimPrefix(string(k), scwPrefix)] = string(v)
+		}
+		hostvars[host] = vars
+
+		for _, t := range s.Tags {
+			addToGroup("tag", t, host)
+		}
+		addToGroup("zone", s.Location.ZoneID, host)
+		addToGroup("type", s.CommercialType, host)
+		addToGroup("security_group", s.SecurityGroup.Name, host)
+	}
+
+	inventory := make(map[string]interface{})
+	all := &ansibleGroup{}
+	for name, g := range groups {
+		sort.Strings(g.Hosts)
+		inventory[name] = g
+		all.Children = append(all.Children, name)
+	}
+	for host := range hostvars {
+		all.Hosts = append(all.Hosts, host)
+	}
+	sort.Strings(all.Hosts)
+	sort.Strings(all.Children)
+	inventory["all"] = all
+	inventory["_meta"] = map[string]interface{}{"hostvars": hostvars}
+
+	return inventory, hostvars, nil
+}
+
+// printAnsibleInventory writes the inventory in the format expected by
+// Ansible for --list or, if host isn't empty, the variables of the host for
+// --host.
+func printAnsibleInventory(w io.Writer, r *serverRole, host string) error {
+	inventory, hostvars, err := r.ansibleInventory()
+	if err != nil {
+		return err
+	}
+
+	var out interface{} = inventory
+	if host != "" {
+		vars, ok := hostvars[host]
+		if !ok {
+			vars = map[string]string{}
+		}
+		out = vars
+	}
+	b, err := json.MarshalIndent(out, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
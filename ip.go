@@ -0,0 +1,99 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/scaleway/go-scaleway"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+var (
+	// ipAddressLabel is the name for the label containing the flexible IP address.
+	ipAddressLabel = scwPrefix + "ip_address"
+	// ipReverseLabel is the name for the label containing the reverse DNS of the flexible IP.
+	ipReverseLabel = scwPrefix + "ip_reverse"
+	// ipServerIDLabel is the name for the label containing the ID of the server attached to the flexible IP.
+	ipServerIDLabel = scwPrefix + "ip_server_id"
+	// ipServerNameLabel is the name for the label containing the name of the server attached to the flexible IP.
+	ipServerNameLabel = scwPrefix + "ip_server_name"
+	// ipUnattachedLabel is the name for the label set to "true" when the flexible IP isn't attached to a server.
+	ipUnattachedLabel = scwPrefix + "ip_unattached"
+)
+
+// ipRole discovers the flexible IPs of all the compute regions. The targets
+// are the bare IP addresses which suits blackbox probing.
+type ipRole struct {
+	client *api.ScalewayAPI
+	logger log.Logger
+}
+
+func (r *ipRole) createTarget(ip *types.ScalewayIPDefinition, region string) *targetgroup.Group {
+	var reverse, serverID, serverName string
+	if ip.Reverse != nil {
+		reverse = *ip.Reverse
+	}
+	if ip.Server != nil {
+		serverID, serverName = ip.Server.Identifier, ip.Server.Name
+	}
+
+	return &targetgroup.Group{
+		Source: fmt.Sprintf("scaleway/ip/%s", ip.ID),
+		Targets: []model.LabelSet{
+			model.LabelSet{
+				model.AddressLabel: model.LabelValue(ip.Address),
+			},
+		},
+		Labels: model.LabelSet{
+			model.AddressLabel:                 model.LabelValue(ip.Address),
+			model.LabelName(identifierLabel):   model.LabelValue(ip.ID),
+			model.LabelName(orgLabel):          model.LabelValue(ip.Organization),
+			model.LabelName(zoneLabel):         model.LabelValue(region),
+			model.LabelName(ipAddressLabel):    model.LabelValue(ip.Address),
+			model.LabelName(ipReverseLabel):    model.LabelValue(reverse),
+			model.LabelName(ipServerIDLabel):   model.LabelValue(serverID),
+			model.LabelName(ipServerNameLabel): model.LabelValue(serverName),
+			model.LabelName(ipUnattachedLabel): model.LabelValue(strconv.FormatBool(serverID == "")),
+		},
+	}
+}
+
+func (r *ipRole) targets() ([]*targetgroup.Group, error) {
+	var tgs []*targetgroup.Group
+	seen := make(map[string]struct{})
+	for _, region := range computeRegions {
+		// Both regions share the same endpoint when SCW_COMPUTE_API is set.
+		if _, ok := seen[computeAPIURL(region)]; ok {
+			continue
+		}
+		seen[computeAPIURL(region)] = struct{}{}
+
+		var ips types.ScalewayGetIPS
+		if err := computeRequest(r.client, region, "ips", &ips); err != nil {
+			return nil, err
+		}
+		level.Debug(r.logger).Log("msg", "get IPs", "region", region, "nb", len(ips.IPS))
+
+		for _, ip := range ips.IPS {
+			tgs = append(tgs, r.createTarget(&ip, region))
+		}
+	}
+	return tgs, nil
+}
@@ -0,0 +1,344 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+var (
+	// kapsuleTargetLabel is the name for the label containing the kind of Kapsule target ("node" or "apiserver").
+	kapsuleTargetLabel = scwPrefix + "kapsule_target"
+	// kapsuleClusterIDLabel is the name for the label containing the ID of the Kapsule cluster.
+	kapsuleClusterIDLabel = scwPrefix + "kapsule_cluster_id"
+	// kapsuleClusterNameLabel is the name for the label containing the name of the Kapsule cluster.
+	kapsuleClusterNameLabel = scwPrefix + "kapsule_cluster_name"
+	// kapsuleVersionLabel is the name for the label containing the Kubernetes version of the cluster.
+	kapsuleVersionLabel = scwPrefix + "kapsule_version"
+	// kapsulePoolIDLabel is the name for the label containing the ID of the node's pool.
+	kapsulePoolIDLabel = scwPrefix + "kapsule_pool_id"
+	// kapsulePoolNameLabel is the name for the label containing the name of the node's pool.
+	kapsulePoolNameLabel = scwPrefix + "kapsule_pool_name"
+	// kapsulePoolVersionLabel is the name for the label containing the Kubernetes version of the node's pool.
+	kapsulePoolVersionLabel = scwPrefix + "kapsule_pool_version"
+	// kapsuleNodeTypeLabel is the name for the label containing the commercial type of the pool's nodes.
+	kapsuleNodeTypeLabel = scwPrefix + "kapsule_node_type"
+	// kapsuleAutoscalingLabel is the name for the label set to "true" when the pool is autoscaled.
+	kapsuleAutoscalingLabel = scwPrefix + "kapsule_autoscaling"
+	// kapsuleAutohealingLabel is the name for the label set to "true" when the pool is autohealed.
+	kapsuleAutohealingLabel = scwPrefix + "kapsule_autohealing"
+	// kapsuleMinSizeLabel is the name for the label containing the minimum size of the pool.
+	kapsuleMinSizeLabel = scwPrefix + "kapsule_min_size"
+	// kapsuleMaxSizeLabel is the name for the label containing the maximum size of the pool.
+	kapsuleMaxSizeLabel = scwPrefix + "kapsule_max_size"
+	// kapsuleInstanceIDLabel is the name for the label containing the ID of the node's instance.
+	kapsuleInstanceIDLabel = scwPrefix + "kapsule_instance_id"
+)
+
+// scwKapsuleCluster is a cluster returned by the Kubernetes API.
+type scwKapsuleCluster struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Status       string   `json:"status"`
+	Version      string   `json:"version"`
+	Region       string   `json:"region"`
+	Tags         []string `json:"tags"`
+	Organization string   `json:"organization_id"`
+	ClusterURL   string   `json:"cluster_url"`
+}
+
+// scwKapsulePool is a node pool of a cluster.
+type scwKapsulePool struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Version     string `json:"version"`
+	NodeType    string `json:"node_type"`
+	Autoscaling bool   `json:"autoscaling"`
+	Autohealing bool   `json:"autohealing"`
+	MinSize     int    `json:"min_size"`
+	MaxSize     int    `json:"max_size"`
+	Zone        string `json:"zone"`
+}
+
+// scwKapsuleNode is a node of a cluster.
+type scwKapsuleNode struct {
+	ID         string `json:"id"`
+	PoolID     string `json:"pool_id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	PublicIPv4 string `json:"public_ip_v4"`
+	ProviderID string `json:"provider_id"`
+}
+
+// kapsuleRole discovers the nodes of the Kubernetes Kapsule clusters and
+// optionally their API servers.
+type kapsuleRole struct {
+	client    *scwAPIClient
+	regions   []string
+	port      int
+	apiServer bool
+	separator string
+	// servers gives the private IPs of the nodes without additional requests
+	// when the server role is enabled, the other instances are requested to
+	// the Instance API.
+	servers *serverRole
+	logger  log.Logger
+
+	// privateIPs caches the private IPs requested to the Instance API by
+	// instance ID.
+	privateIPs map[string]string
+}
+
+func (r *kapsuleRole) getClusters(region string) ([]scwKapsuleCluster, error) {
+	var clusters []scwKapsuleCluster
+	err := r.client.list(fmt.Sprintf("/k8s/v1/regions/%s/clusters", region), func(b json.RawMessage) (int, int, error) {
+		var resp struct {
+			Clusters   []scwKapsuleCluster `json:"clusters"`
+			TotalCount int                 `json:"total_count"`
+		}
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return 0, 0, err
+		}
+		clusters = append(clusters, resp.Clusters...)
+		return len(resp.Clusters), resp.TotalCount, nil
+	})
+	return clusters, err
+}
+
+func (r *kapsuleRole) getPools(region, cluster string) ([]scwKapsulePool, error) {
+	var pools []scwKapsulePool
+	err := r.client.list(fmt.Sprintf("/k8s/v1/regions/%s/clusters/%s/pools", region, cluster), func(b json.RawMessage) (int, int, error) {
+		var resp struct {
+			Pools      []scwKapsulePool `json:"pools"`
+			TotalCount int              `json:"total_count"`
+		}
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return 0, 0, err
+		}
+		pools = append(pools, resp.Pools...)
+		return len(resp.Pools), resp.TotalCount, nil
+	})
+	return pools, err
+}
+
+func (r *kapsuleRole) getNodes(region, cluster string) ([]scwKapsuleNode, error) {
+	var nodes []scwKapsuleNode
+	err := r.client.list(fmt.Sprintf("/k8s/v1/regions/%s/clusters/%s/nodes", region, cluster), func(b json.RawMessage) (int, int, error) {
+		var resp struct {
+			Nodes      []scwKapsuleNode `json:"nodes"`
+			TotalCount int              `json:"total_count"`
+		}
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return 0, 0, err
+		}
+		nodes = append(nodes, resp.Nodes...)
+		return len(resp.Nodes), resp.TotalCount, nil
+	})
+	return nodes, err
+}
+
+// clusterLabels returns the labels shared by all the targets of a cluster.
+func (r *kapsuleRole) clusterLabels(c *scwKapsuleCluster) model.LabelSet {
+	return model.LabelSet{
+		model.LabelName(orgLabel):                model.LabelValue(c.Organization),
+		model.LabelName(tagsLabel):               model.LabelValue(joinTags(c.Tags, r.separator)),
+		model.LabelName(kapsuleClusterIDLabel):   model.LabelValue(c.ID),
+		model.LabelName(kapsuleClusterNameLabel): model.LabelValue(c.Name),
+		model.LabelName(kapsuleVersionLabel):     model.LabelValue(c.Version),
+	}
+}
+
+// instanceID returns the ID of the instance from the provider ID of the
+// node (scaleway://instance/<zone>/<id>).
+func instanceID(providerID string) string {
+	if i := strings.LastIndex(providerID, "/"); i >= 0 {
+		return providerID[i+1:]
+	}
+	return ""
+}
+
+// instanceZone returns the zone of the instance from the provider ID of the
+// node or an empty string if it isn't present.
+func instanceZone(providerID string) string {
+	parts := strings.Split(strings.TrimPrefix(providerID, "scaleway://"), "/")
+	if len(parts) == 3 && parts[0] == "instance" {
+		return parts[1]
+	}
+	return ""
+}
+
+// resolvePrivateIPs completes privateIPs with the private IPs of the nodes'
+// instances which aren't known by the server role. The results are cached
+// for the instances which still exist.
+func (r *kapsuleRole) resolvePrivateIPs(nodes []scwKapsuleNode, pools map[string]*scwKapsulePool, privateIPs map[string]string, cache map[string]string) {
+	for _, n := range nodes {
+		id := instanceID(n.ProviderID)
+		if id == "" || privateIPs[id] != "" {
+			continue
+		}
+		if ip, ok := r.privateIPs[id]; ok {
+			privateIPs[id], cache[id] = ip, ip
+			continue
+		}
+		zone := instanceZone(n.ProviderID)
+		if zone == "" {
+			if p, ok := pools[n.PoolID]; ok {
+				zone = p.Zone
+			}
+		}
+		if zone == "" {
+			continue
+		}
+
+		var resp struct {
+			Server struct {
+				PrivateIP string `json:"private_ip"`
+			} `json:"server"`
+		}
+		if err := r.client.get(fmt.Sprintf("/instance/v1/zones/%s/servers/%s", zone, id), nil, &resp); err != nil {
+			level.Warn(r.logger).Log("msg", "failed to get the instance of the node", "node", n.ID, "instance", id, "err", err)
+			continue
+		}
+		if ip := resp.Server.PrivateIP; ip != "" {
+			privateIPs[id], cache[id] = ip, ip
+		}
+	}
+}
+
+func (r *kapsuleRole) createNodeTarget(c *scwKapsuleCluster, p *scwKapsulePool, n *scwKapsuleNode, privateIPs map[string]string) *targetgroup.Group {
+	id := instanceID(n.ProviderID)
+	privateIP := privateIPs[id]
+	addr := privateIP
+	if addr == "" {
+		addr = n.PublicIPv4
+	}
+
+	labels := model.LabelSet{
+		model.LabelName(identifierLabel):         model.LabelValue(n.ID),
+		model.LabelName(nameLabel):               model.LabelValue(n.Name),
+		model.LabelName(stateLabel):              model.LabelValue(n.Status),
+		model.LabelName(zoneLabel):               model.LabelValue(p.Zone),
+		model.LabelName(privateIPLabel):          model.LabelValue(privateIP),
+		model.LabelName(publicIPLabel):           model.LabelValue(n.PublicIPv4),
+		model.LabelName(kapsuleTargetLabel):      "node",
+		model.LabelName(kapsuleInstanceIDLabel):  model.LabelValue(id),
+		model.LabelName(kapsulePoolIDLabel):      model.LabelValue(p.ID),
+		model.LabelName(kapsulePoolNameLabel):    model.LabelValue(p.Name),
+		model.LabelName(kapsulePoolVersionLabel): model.LabelValue(p.Version),
+		model.LabelName(kapsuleNodeTypeLabel):    model.LabelValue(p.NodeType),
+		model.LabelName(kapsuleAutoscalingLabel): model.LabelValue(strconv.FormatBool(p.Autoscaling)),
+		model.LabelName(kapsuleAutohealingLabel): model.LabelValue(strconv.FormatBool(p.Autohealing)),
+		model.LabelName(kapsuleMinSizeLabel):     model.LabelValue(strconv.Itoa(p.MinSize)),
+		model.LabelName(kapsuleMaxSizeLabel):     model.LabelValue(strconv.Itoa(p.MaxSize)),
+	}
+	for k, v := range r.clusterLabels(c) {
+		labels[k] = v
+	}
+
+	var targets []model.LabelSet
+	if addr != "" {
+		targets = append(targets, model.LabelSet{
+			model.AddressLabel: model.LabelValue(net.JoinHostPort(addr, strconv.Itoa(r.port))),
+		})
+	} else {
+		level.Warn(r.logger).Log("msg", "no address found for the node", "node", n.ID, "instance", id)
+	}
+	return &targetgroup.Group{
+		Source:  fmt.Sprintf("scaleway/kapsule/%s/%s/%s", c.Region, c.ID, n.ID),
+		Targets: targets,
+		Labels:  labels,
+	}
+}
+
+func (r *kapsuleRole) createAPIServerTarget(c *scwKapsuleCluster) *targetgroup.Group {
+	labels := r.clusterLabels(c)
+	labels[model.LabelName(identifierLabel)] = model.LabelValue(c.ID)
+	labels[model.LabelName(nameLabel)] = model.LabelValue(c.Name)
+	labels[model.LabelName(stateLabel)] = model.LabelValue(c.Status)
+	labels[model.LabelName(zoneLabel)] = model.LabelValue(c.Region)
+	labels[model.LabelName(kapsuleTargetLabel)] = "apiserver"
+
+	var targets []model.LabelSet
+	if u, err := url.Parse(c.ClusterURL); err == nil && u.Host != "" {
+		targets = append(targets, model.LabelSet{
+			model.AddressLabel: model.LabelValue(c.ClusterURL),
+		})
+	}
+	return &targetgroup.Group{
+		Source:  fmt.Sprintf("scaleway/kapsule/%s/%s", c.Region, c.ID),
+		Targets: targets,
+		Labels:  labels,
+	}
+}
+
+func (r *kapsuleRole) targets() ([]*targetgroup.Group, error) {
+	privateIPs := make(map[string]string)
+	for _, s := range r.servers.cachedServers() {
+		privateIPs[s.Identifier] = s.PrivateIP
+	}
+	cache := make(map[string]string)
+
+	var tgs []*targetgroup.Group
+	for _, region := range r.regions {
+		clusters, err := r.getClusters(region)
+		if err != nil {
+			return nil, err
+		}
+		level.Debug(r.logger).Log("msg", "get Kapsule clusters", "region", region, "nb", len(clusters))
+
+		for i := range clusters {
+			c := &clusters[i]
+			if c.Region == "" {
+				c.Region = region
+			}
+			if r.apiServer {
+				tgs = append(tgs, r.createAPIServerTarget(c))
+			}
+
+			pools, err := r.getPools(region, c.ID)
+			if err != nil {
+				return nil, err
+			}
+			byID := make(map[string]*scwKapsulePool, len(pools))
+			for j := range pools {
+				byID[pools[j].ID] = &pools[j]
+			}
+			nodes, err := r.getNodes(region, c.ID)
+			if err != nil {
+				return nil, err
+			}
+			r.resolvePrivateIPs(nodes, byID, privateIPs, cache)
+			for j := range nodes {
+				p, ok := byID[nodes[j].PoolID]
+				if !ok {
+					p = &scwKapsulePool{ID: nodes[j].PoolID}
+				}
+				tgs = append(tgs, r.createNodeTarget(c, p, &nodes[j], privateIPs))
+			}
+		}
+	}
+	r.privateIPs = cache
+	return tgs, nil
+}
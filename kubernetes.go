@@ -0,0 +1,400 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// kubernetesMaxRetries is the number of times a write is retried when
+	// another writer modified the same object concurrently.
+	kubernetesMaxRetries = 5
+	// managedByLabel identifies the ScrapeConfig objects owned by this program.
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "prometheus-scw-sd"
+	// serviceAccountNamespace holds the namespace of the pod when running in-cluster.
+	serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// scrapeConfig is the subset of the Prometheus Operator ScrapeConfig custom
+// resource (monitoring.coreos.com/v1alpha1) managed by kubernetesOutput.
+type scrapeConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              scrapeConfigSpec `json:"spec"`
+}
+
+// existingScrapeConfig is a ScrapeConfig read from the API server. The spec
+// is kept raw so that the fields set by the users (relabelings, scheme, ...)
+// are sent back untouched when the static configs are updated.
+type existingScrapeConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              map[string]json.RawMessage `json:"spec"`
+}
+
+type scrapeConfigSpec struct {
+	StaticConfigs []staticConfig `json:"staticConfigs,omitempty"`
+}
+
+type staticConfig struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+type scrapeConfigList struct {
+	Items []scrapeConfig `json:"items"`
+}
+
+// kubernetesOutput writes the target groups into a ConfigMap key and
+// optionally into one Prometheus Operator ScrapeConfig object per job.
+//
+// Updates rely on the optimistic concurrency of the Kubernetes API: objects
+// are only written when their content changes and writes are retried on
+// conflict so that several replicas can safely manage the same objects.
+type kubernetesOutput struct {
+	client       kubernetes.Interface
+	crdClient    *rest.RESTClient
+	namespace    string
+	configMap    string
+	key          string
+	scrapePrefix string
+	logger       log.Logger
+}
+
+func newKubernetesOutput(kubeconfig, namespace, configMap, key string, scrapeConfigs bool, scrapePrefix string, logger log.Logger) (*kubernetesOutput, error) {
+	cfg, err := kubernetesConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if namespace == "" {
+		namespace = "default"
+		if b, err := ioutil.ReadFile(serviceAccountNamespace); err == nil {
+			namespace = strings.TrimSpace(string(b))
+		}
+	}
+
+	o := &kubernetesOutput{
+		client:       client,
+		namespace:    namespace,
+		configMap:    configMap,
+		key:          key,
+		scrapePrefix: scrapePrefix,
+		logger:       log.With(logger, "component", "kubernetes"),
+	}
+
+	if scrapeConfigs {
+		crdCfg := *cfg
+		crdCfg.APIPath = "/apis"
+		crdCfg.GroupVersion = &schema.GroupVersion{Group: "monitoring.coreos.com", Version: "v1alpha1"}
+		crdCfg.NegotiatedSerializer = scheme.Codecs
+		o.crdClient, err = rest.RESTClientFor(&crdCfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// Write implements the Output interface.
+func (o *kubernetesOutput) Write(groups []customSD) error {
+	if o.configMap != "" {
+		b, _ := json.MarshalIndent(groups, "", "    ")
+		if err := retryOnConflict(func() error { return o.writeConfigMap(string(b)) }); err != nil {
+			return fmt.Errorf("failed to write ConfigMap %s/%s: %v", o.namespace, o.configMap, err)
+		}
+	}
+	if o.crdClient != nil {
+		if err := o.writeScrapeConfigs(groups); err != nil {
+			return fmt.Errorf("failed to write ScrapeConfigs: %v", err)
+		}
+	}
+	return nil
+}
+
+func (o *kubernetesOutput) writeConfigMap(data string) error {
+	cms := o.client.CoreV1().ConfigMaps(o.namespace)
+	cm, err := cms.Get(o.configMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = cms.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: o.configMap, Namespace: o.namespace},
+			Data:       map[string]string{o.key: data},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if cm.Data[o.key] == data {
+		return nil
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[o.key] = data
+	// The update fails with a conflict if the ConfigMap has been modified
+	// since it was read.
+	_, err = cms.Update(cm)
+	if err == nil {
+		level.Debug(o.logger).Log("msg", "ConfigMap updated", "namespace", o.namespace, "name", o.configMap)
+	}
+	return err
+}
+
+func (o *kubernetesOutput) writeScrapeConfigs(groups []customSD) error {
+	specs := make(map[string]*scrapeConfigSpec)
+	for _, g := range groups {
+		if len(g.Targets) == 0 {
+			continue
+		}
+		name := o.scrapeConfigName(g.job)
+		if _, ok := specs[name]; !ok {
+			specs[name] = &scrapeConfigSpec{}
+		}
+		labels := make(map[string]string, len(g.Labels))
+		for k, v := range g.Labels {
+			if k == model.AddressLabel {
+				continue
+			}
+			labels[k] = v
+		}
+		specs[name].StaticConfigs = append(specs[name].StaticConfigs, staticConfig{Targets: g.Targets, Labels: labels})
+	}
+
+	for name, spec := range specs {
+		spec := spec
+		name := name
+		if err := retryOnConflict(func() error { return o.writeScrapeConfig(name, spec) }); err != nil {
+			return err
+		}
+	}
+
+	// Delete the objects of the jobs which don't exist anymore.
+	b, err := o.crdClient.Get().
+		Namespace(o.namespace).
+		Resource("scrapeconfigs").
+		Param("labelSelector", managedByLabel+"="+managedByValue).
+		Do().
+		Raw()
+	if err != nil {
+		return err
+	}
+	var list scrapeConfigList
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	for _, sc := range list.Items {
+		if _, ok := specs[sc.Name]; ok {
+			continue
+		}
+		err := o.crdClient.Delete().Namespace(o.namespace).Resource("scrapeconfigs").Name(sc.Name).Do().Error()
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		level.Debug(o.logger).Log("msg", "ScrapeConfig deleted", "namespace", o.namespace, "name", sc.Name)
+	}
+	return nil
+}
+
+func (o *kubernetesOutput) writeScrapeConfig(name string, spec *scrapeConfigSpec) error {
+	b, err := o.crdClient.Get().Namespace(o.namespace).Resource("scrapeconfigs").Name(name).Do().Raw()
+	if apierrors.IsNotFound(err) {
+		sc := scrapeConfig{
+			TypeMeta: metav1.TypeMeta{APIVersion: "monitoring.coreos.com/v1alpha1", Kind: "ScrapeConfig"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: o.namespace,
+				Labels:    map[string]string{managedByLabel: managedByValue},
+			},
+			Spec: *spec,
+		}
+		body, _ := json.Marshal(sc)
+		return o.crdClient.Post().Namespace(o.namespace).Resource("scrapeconfigs").Body(body).Do().Error()
+	}
+	if err != nil {
+		return err
+	}
+
+	var sc existingScrapeConfig
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return err
+	}
+	// Only the static configs are owned by this program. They are compared
+	// after a round trip so that the formatting of the API server doesn't
+	// matter.
+	want, _ := json.Marshal(spec.StaticConfigs)
+	if raw, ok := sc.Spec["staticConfigs"]; ok {
+		var current []staticConfig
+		if err := json.Unmarshal(raw, &current); err == nil {
+			if got, _ := json.Marshal(current); bytes.Equal(got, want) {
+				return nil
+			}
+		}
+	}
+	// The existing resourceVersion is sent back so that the update fails
+	// with a conflict if the object has been modified since it was read.
+	if sc.Spec == nil {
+		sc.Spec = make(map[string]json.RawMessage)
+	}
+	sc.Spec["staticConfigs"] = want
+	body, _ := json.Marshal(sc)
+	err = o.crdClient.Put().Namespace(o.namespace).Resource("scrapeconfigs").Name(name).Body(body).Do().Error()
+	if err == nil {
+		level.Debug(o.logger).Log("msg", "ScrapeConfig updated", "namespace", o.namespace, "name", name)
+	}
+	return err
+}
+
+// scrapeConfigName returns a valid Kubernetes object name for the given job.
+func (o *kubernetesOutput) scrapeConfigName(job string) string {
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(job), "-")
+	return strings.Trim(o.scrapePrefix+"-"+name, "-")
+}
+
+// retryOnConflict runs f until it succeeds or fails with an error which isn't
+// caused by a concurrent modification.
+func retryOnConflict(f func() error) error {
+	var err error
+	for i := 0; i < kubernetesMaxRetries; i++ {
+		err = f()
+		if !apierrors.IsConflict(err) && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// kubernetesConfig returns the in-cluster configuration if kubeconfig is
+// empty, otherwise the configuration of the current context of the file.
+func kubernetesConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig == "" {
+		return rest.InClusterConfig()
+	}
+	return loadKubeconfig(kubeconfig)
+}
+
+// kubeconfigFile is the subset of the kubeconfig format needed to connect to the API server.
+type kubeconfigFile struct {
+	CurrentContext string `json:"current-context"`
+	Clusters       []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server                   string `json:"server"`
+			CertificateAuthority     string `json:"certificate-authority"`
+			CertificateAuthorityData []byte `json:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Contexts []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			ClientCertificate     string `json:"client-certificate"`
+			ClientCertificateData []byte `json:"client-certificate-data"`
+			ClientKey             string `json:"client-key"`
+			ClientKeyData         []byte `json:"client-key-data"`
+			Token                 string `json:"token"`
+			Username              string `json:"username"`
+			Password              string `json:"password"`
+		} `json:"user"`
+	} `json:"users"`
+}
+
+func loadKubeconfig(filename string) (*rest.Config, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var kc kubeconfigFile
+	if err := yaml.Unmarshal(b, &kc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", filename, err)
+	}
+
+	var clusterName, userName string
+	for _, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+		}
+	}
+	if clusterName == "" {
+		return nil, fmt.Errorf("context %q not found in %s", kc.CurrentContext, filename)
+	}
+
+	// Relative paths are resolved against the directory of the kubeconfig file.
+	dir := filepath.Dir(filename)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	cfg := &rest.Config{}
+	for _, c := range kc.Clusters {
+		if c.Name == clusterName {
+			cfg.Host = c.Cluster.Server
+			cfg.TLSClientConfig.CAFile = resolve(c.Cluster.CertificateAuthority)
+			cfg.TLSClientConfig.CAData = c.Cluster.CertificateAuthorityData
+			cfg.TLSClientConfig.Insecure = c.Cluster.InsecureSkipTLSVerify
+		}
+	}
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("cluster %q not found in %s", clusterName, filename)
+	}
+	for _, u := range kc.Users {
+		if u.Name == userName {
+			cfg.TLSClientConfig.CertFile = resolve(u.User.ClientCertificate)
+			cfg.TLSClientConfig.CertData = u.User.ClientCertificateData
+			cfg.TLSClientConfig.KeyFile = resolve(u.User.ClientKey)
+			cfg.TLSClientConfig.KeyData = u.User.ClientKeyData
+			cfg.BearerToken = u.User.Token
+			cfg.Username = u.User.Username
+			cfg.Password = u.User.Password
+		}
+	}
+	return cfg, nil
+}
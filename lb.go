@@ -0,0 +1,201 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+var (
+	// lbTypeLabel is the name for the label containing the commercial type of the load balancer.
+	lbTypeLabel = scwPrefix + "lb_type"
+	// lbFrontendIDLabel is the name for the label containing the ID of the load balancer's frontend.
+	lbFrontendIDLabel = scwPrefix + "lb_frontend_id"
+	// lbFrontendNameLabel is the name for the label containing the name of the load balancer's frontend.
+	lbFrontendNameLabel = scwPrefix + "lb_frontend_name"
+	// lbFrontendPortLabel is the name for the label containing the inbound port of the load balancer's frontend.
+	lbFrontendPortLabel = scwPrefix + "lb_frontend_port"
+	// lbBackendIDLabel is the name for the label containing the ID of the frontend's backend.
+	lbBackendIDLabel = scwPrefix + "lb_backend_id"
+	// lbBackendNameLabel is the name for the label containing the name of the frontend's backend.
+	lbBackendNameLabel = scwPrefix + "lb_backend_name"
+	// lbBackendIPsLabel is the name for the label containing the IPs of the backend's servers.
+	lbBackendIPsLabel = scwPrefix + "lb_backend_server_ips"
+	// lbBackendServerIDsLabel is the name for the label containing the IDs of the backend's servers.
+	lbBackendServerIDsLabel = scwPrefix + "lb_backend_server_ids"
+)
+
+// scwLB is a load balancer returned by the Load Balancer API.
+type scwLB struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Status       string   `json:"status"`
+	Type         string   `json:"type"`
+	Tags         []string `json:"tags"`
+	Organization string   `json:"organization_id"`
+	Zone         string   `json:"zone"`
+	IPs          []struct {
+		ID      string `json:"id"`
+		Address string `json:"ip_address"`
+	} `json:"ip"`
+}
+
+// scwLBFrontend is a frontend of a load balancer.
+type scwLBFrontend struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	InboundPort int    `json:"inbound_port"`
+	Backend     struct {
+		ID   string   `json:"id"`
+		Name string   `json:"name"`
+		Pool []string `json:"pool"`
+	} `json:"backend"`
+}
+
+// lbRole discovers the frontends of the load balancers. The targets are the
+// IPs of the load balancer with the frontend's port.
+type lbRole struct {
+	client    *scwAPIClient
+	zones     []string
+	separator string
+	// servers resolves the backend IPs to server IDs, it is nil when the
+	// server role isn't enabled.
+	servers *serverRole
+	logger  log.Logger
+}
+
+func (r *lbRole) getLBs(zone string) ([]scwLB, error) {
+	var lbs []scwLB
+	err := r.client.list(fmt.Sprintf("/lb/v1/zones/%s/lbs", zone), func(b json.RawMessage) (int, int, error) {
+		var resp struct {
+			LBs        []scwLB `json:"lbs"`
+			TotalCount int     `json:"total_count"`
+		}
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return 0, 0, err
+		}
+		lbs = append(lbs, resp.LBs...)
+		return len(resp.LBs), resp.TotalCount, nil
+	})
+	return lbs, err
+}
+
+func (r *lbRole) getFrontends(zone, id string) ([]scwLBFrontend, error) {
+	var frontends []scwLBFrontend
+	err := r.client.list(fmt.Sprintf("/lb/v1/zones/%s/lbs/%s/frontends", zone, id), func(b json.RawMessage) (int, int, error) {
+		var resp struct {
+			Frontends  []scwLBFrontend `json:"frontends"`
+			TotalCount int             `json:"total_count"`
+		}
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return 0, 0, err
+		}
+		frontends = append(frontends, resp.Frontends...)
+		return len(resp.Frontends), resp.TotalCount, nil
+	})
+	return frontends, err
+}
+
+// serverIDs returns the IDs of the servers having one of the given IPs.
+func serverIDs(byIP map[string]string, ips []string) []string {
+	var ids []string
+	for _, ip := range ips {
+		if id, ok := byIP[ip]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (r *lbRole) createTarget(lb *scwLB, f *scwLBFrontend, byIP map[string]string) *targetgroup.Group {
+	port := strconv.Itoa(f.InboundPort)
+	targets := make([]model.LabelSet, 0, len(lb.IPs))
+	for _, ip := range lb.IPs {
+		targets = append(targets, model.LabelSet{
+			model.AddressLabel: model.LabelValue(net.JoinHostPort(ip.Address, port)),
+		})
+	}
+
+	return &targetgroup.Group{
+		Source:  fmt.Sprintf("scaleway/lb/%s/%s/%s", lb.Zone, lb.ID, f.ID),
+		Targets: targets,
+		Labels: model.LabelSet{
+			model.LabelName(identifierLabel):         model.LabelValue(lb.ID),
+			model.LabelName(nameLabel):               model.LabelValue(lb.Name),
+			model.LabelName(orgLabel):                model.LabelValue(lb.Organization),
+			model.LabelName(stateLabel):              model.LabelValue(lb.Status),
+			model.LabelName(tagsLabel):               model.LabelValue(joinTags(lb.Tags, r.separator)),
+			model.LabelName(zoneLabel):               model.LabelValue(lb.Zone),
+			model.LabelName(lbTypeLabel):             model.LabelValue(lb.Type),
+			model.LabelName(lbFrontendIDLabel):       model.LabelValue(f.ID),
+			model.LabelName(lbFrontendNameLabel):     model.LabelValue(f.Name),
+			model.LabelName(lbFrontendPortLabel):     model.LabelValue(port),
+			model.LabelName(lbBackendIDLabel):        model.LabelValue(f.Backend.ID),
+			model.LabelName(lbBackendNameLabel):      model.LabelValue(f.Backend.Name),
+			model.LabelName(lbBackendIPsLabel):       model.LabelValue(joinTags(f.Backend.Pool, r.separator)),
+			model.LabelName(lbBackendServerIDsLabel): model.LabelValue(joinTags(serverIDs(byIP, f.Backend.Pool), r.separator)),
+		},
+	}
+}
+
+func (r *lbRole) targets() ([]*targetgroup.Group, error) {
+	// Index the servers by private and public IP.
+	byIP := make(map[string]string)
+	if r.servers != nil {
+		srvs, err := r.servers.syncedServers()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range srvs {
+			byIP[s.PrivateIP] = s.Identifier
+			if s.PublicAddress.IP != "" {
+				byIP[s.PublicAddress.IP] = s.Identifier
+			}
+		}
+	}
+
+	var tgs []*targetgroup.Group
+	for _, zone := range r.zones {
+		lbs, err := r.getLBs(zone)
+		if err != nil {
+			return nil, err
+		}
+		level.Debug(r.logger).Log("msg", "get load balancers", "zone", zone, "nb", len(lbs))
+
+		for i := range lbs {
+			lb := &lbs[i]
+			if lb.Zone == "" {
+				lb.Zone = zone
+			}
+			frontends, err := r.getFrontends(zone, lb.ID)
+			if err != nil {
+				return nil, err
+			}
+			for j := range frontends {
+				tgs = append(tgs, r.createTarget(lb, &frontends[j], byIP))
+			}
+		}
+	}
+	return tgs, nil
+}
@@ -16,11 +16,14 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -29,7 +32,9 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/version"
+	"github.com/prometheus/prometheus/discovery"
 	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/util/strutil"
 	"github.com/scaleway/go-scaleway"
 	"github.com/scaleway/go-scaleway/types"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -37,13 +42,50 @@ import (
 
 var (
 	a            = kingpin.New("sd adapter usage", "Tool to generate Prometheus file_sd target files for Scaleway.")
-	outputf      = a.Flag("output.file", "The output filename for file_sd compatible file.").Default("scw.json").String()
+	runCmd       = a.Command("run", "Run the service discovery.").Default()
+	inventoryCmd = a.Command("ansible-inventory", "Print an Ansible dynamic inventory of the Scaleway servers.")
+	inventoryHst = inventoryCmd.Flag("host", "Print the variables of the given host.").Default("").String()
+	// --list is accepted for compatibility with Ansible, it is the default behavior when --host isn't set.
+	inventoryLst = inventoryCmd.Flag("list", "Print all the groups and hosts (default).").Bool()
+	outputf      = a.Flag("output.file", "The output filename for file_sd compatible file (\"-\" for the standard output).").Default("scw.json").String()
+	once         = a.Flag("once", "Run the discovery once, write the outputs and exit.").Bool()
+	exportInv    = a.Flag("exporter.inventory", "Expose the inventory of the Scaleway servers as metrics.").Bool()
+	templates    = a.Flag("output.template", "A Go template file and the file to render it to (eg hosts.tmpl=hosts). Can be repeated.").StringMap()
 	organization = a.Flag("scw.organization", "The Scaleway organization.").Default("").String()
 	region       = a.Flag("scw.region", "The Scaleway region.").Default("").String()
 	tokenf       = a.Flag("scw.token-file", "The authentication token file.").Default("").String()
+	sgRules      = a.Flag("scw.security-group.rules", "Only emit the exporter ports accepted by the inbound rules of the servers' security groups.").Bool()
+	sgSource     = a.Flag("scw.security-group.source-range", "The IP range of the Prometheus servers checked against the security group rules.").Default("0.0.0.0/0").String()
+	sgCacheTTL   = a.Flag("scw.security-group.cache-ttl", "How long the security group rules are cached.").Default("5m").Duration()
+	mpLabels     = a.Flag("scw.marketplace", "Add the labels of the marketplace image to the server targets.").Bool()
+	mpCacheTTL   = a.Flag("scw.marketplace.cache-ttl", "How long the marketplace images are cached.").Default("1h").Duration()
+	logLevel     = a.Flag("log.level", "Only log messages with the given severity or above.").Default("info").Enum("debug", "info", "warn", "error")
+	logFormat    = a.Flag("log.format", "The output format of the log messages.").Default("logfmt").Enum("logfmt", "json")
+	roles        = a.Flag("scw.role", "The Scaleway resources to discover (server, ip, bucket, lb, kapsule, rdb, baremetal, container or function). Can be repeated.").Default("server").Enums("server", "ip", "bucket", "lb", "kapsule", "rdb", "baremetal", "container", "function")
+	zones        = a.Flag("scw.zone", "A zone queried by the zoned roles (eg fr-par-1), the regional roles query the regions of the zones. Can be repeated.").Default("fr-par-1").Strings()
+	kapsulePort  = a.Flag("scw.kapsule.port", "The port of the Kapsule node targets.").Default("10250").Int()
+	kapsuleAPI   = a.Flag("scw.kapsule.api-server", "Also emit the API server URL of the Kapsule clusters as targets.").Bool()
 	refresh      = a.Flag("target.refresh", "The refresh interval (in seconds).").Default("30").Int()
 	port         = a.Flag("target.port", "The default port number for targets.").Default("80").Int()
+	exporterPort = a.Flag("target.exporter-port", "An exporter port checked against the security group rules (--target.port if not set). Can be repeated.").Ints()
 	listen       = a.Flag("web.listen-address", "The listen address.").Default(":9465").String()
+	webConfigf   = a.Flag("web.config.file", "The web configuration file enabling TLS and basic authentication (exporter-toolkit format).").Default("").String()
+	historySize  = a.Flag("web.history-size", "The maximum number of target changes kept in memory.").Default("1000").Int()
+	readyTol     = a.Flag("web.ready-tolerance", "The number of refresh intervals without successful refresh before /-/ready fails.").Default("3").Int()
+	dnsListen    = a.Flag("dns.listen-address", "The listen address of the DNS server (disabled if empty).").Default("").String()
+	dnsDomain    = a.Flag("dns.domain", "The domain served by the DNS server.").Default("scw.local").String()
+	dnsTTL       = a.Flag("dns.ttl", "The TTL of the DNS records.").Default("30s").Duration()
+	dnsServices  = a.Flag("dns.service", "The port of a SRV service (eg node=9100). Can be repeated.").StringMap()
+	webhookURL   = a.Flag("webhook.url", "The URL notified of the target changes (disabled if empty).").Default("").String()
+	webhookQueue = a.Flag("webhook.queue-size", "The maximum number of pending webhook notifications.").Default("100").Int()
+	webhookRetry = a.Flag("webhook.max-retries", "The maximum number of retries of a webhook notification.").Default("5").Int()
+	webhookTmout = a.Flag("webhook.timeout", "The timeout of the webhook requests.").Default("10s").Duration()
+	kubeconfig   = a.Flag("kubernetes.kubeconfig", "The kubeconfig file (in-cluster configuration if empty).").Default("").String()
+	kubeNS       = a.Flag("kubernetes.namespace", "The namespace of the Kubernetes objects (namespace of the pod if empty).").Default("").String()
+	kubeCM       = a.Flag("kubernetes.configmap", "The name of the ConfigMap to write the targets to (disabled if empty).").Default("").String()
+	kubeCMKey    = a.Flag("kubernetes.configmap-key", "The ConfigMap key holding the targets.").Default("scw.json").String()
+	kubeSC       = a.Flag("kubernetes.scrape-configs", "Generate one Prometheus Operator ScrapeConfig object per job.").Bool()
+	kubeSCPrefix = a.Flag("kubernetes.scrape-config-prefix", "The name prefix of the generated ScrapeConfig objects.").Default("scaleway").String()
 
 	scwPrefix = model.MetaLabelPrefix + "scaleway_"
 	// archLabel is the name for the label containing the server's architecture.
@@ -82,6 +124,38 @@ var (
 	clusterLabel = scwPrefix + "cluster_id"
 	// zoneLabel is the name for the label containing all the server's zone location.
 	zoneLabel = scwPrefix + "zone_id"
+	// bootscriptIDLabel is the name for the label containing the ID of the server's bootscript.
+	bootscriptIDLabel = scwPrefix + "bootscript_id"
+	// bootscriptTitleLabel is the name for the label containing the title of the server's bootscript.
+	bootscriptTitleLabel = scwPrefix + "bootscript_title"
+	// bootscriptKernelVersionLabel is the name for the label containing the kernel version of the server's bootscript.
+	bootscriptKernelVersionLabel = scwPrefix + "bootscript_kernel_version"
+	// marketplaceIDLabel is the name for the label containing the marketplace image of the server's image.
+	marketplaceIDLabel = scwPrefix + "marketplace_image_id"
+	// marketplaceCategoriesLabel is the name for the label containing the categories of the marketplace image.
+	marketplaceCategoriesLabel = scwPrefix + "marketplace_categories"
+	// marketplaceVersionLabel is the name for the label containing the name of the marketplace image version.
+	marketplaceVersionLabel = scwPrefix + "marketplace_version"
+	// marketplaceOutdatedLabel is the name for the label set to "true" when a newer version of the marketplace image exists.
+	marketplaceOutdatedLabel = scwPrefix + "marketplace_outdated"
+	// blockedPortsLabel is the name for the label containing the exporter ports blocked by the server's security group.
+	blockedPortsLabel = scwPrefix + "blocked_ports"
+	// volumePrefix is the prefix of the labels containing the ID, type and size of the volume in each slot.
+	volumePrefix = scwPrefix + "volume_"
+	// volumesCountLabel is the name for the label containing the number of volumes of the server.
+	volumesCountLabel = scwPrefix + "volumes_count"
+	// volumesSizeLabel is the name for the label containing the total size of the server's volumes in bytes.
+	volumesSizeLabel = scwPrefix + "volumes_total_size_bytes"
+	// volumesLSSDLabel is the name for the label set to "true" when the server has a local SSD volume.
+	volumesLSSDLabel = scwPrefix + "volumes_has_l_ssd"
+	// volumesBSSDLabel is the name for the label set to "true" when the server has a block SSD volume.
+	volumesBSSDLabel = scwPrefix + "volumes_has_b_ssd"
+	// roleLabel is the name for the label containing the role which discovered the target.
+	roleLabel = scwPrefix + "role"
+	// securityGroupIDLabel is the name for the label containing the server's security group ID.
+	securityGroupIDLabel = scwPrefix + "security_group_id"
+	// securityGroupNameLabel is the name for the label containing the server's security group name.
+	securityGroupNameLabel = scwPrefix + "security_group_name"
 )
 
 var (
@@ -99,6 +173,41 @@ var (
 			Help: "Total number of failed requests to the Scaleway API.",
 		},
 	)
+	refreshDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "prometheus_scaleway_sd_refresh_duration_seconds",
+			Help:    "Histogram of the durations of the discovery refreshes.",
+			Buckets: []float64{0.01, 0.1, 0.5, 1.0, 2.0, 5.0, 10.0, 30.0},
+		},
+		[]string{"job"},
+	)
+	lastRefreshSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "prometheus_scaleway_sd_last_refresh_success_timestamp_seconds",
+			Help: "Timestamp of the last successful discovery refresh.",
+		},
+		[]string{"job"},
+	)
+	targetsAdded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "prometheus_scaleway_sd_targets_added_total",
+			Help: "Total number of targets which appeared since the previous refresh.",
+		},
+		[]string{"job"},
+	)
+	targetsRemoved = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "prometheus_scaleway_sd_targets_removed_total",
+			Help: "Total number of targets which disappeared since the previous refresh.",
+		},
+		[]string{"job"},
+	)
+	targetsDesc = prometheus.NewDesc(
+		"prometheus_scaleway_sd_targets",
+		"Number of discovered targets.",
+		[]string{"job", "zone", "state"},
+		nil,
+	)
 )
 
 func init() {
@@ -107,12 +216,71 @@ func init() {
 	reg.MustRegister(version.NewCollector("prometheus_scaleway_sd"))
 	reg.MustRegister(requestDuration)
 	reg.MustRegister(requestFailures)
+	reg.MustRegister(refreshDuration)
+	reg.MustRegister(lastRefreshSuccess)
+	reg.MustRegister(targetsAdded)
+	reg.MustRegister(targetsRemoved)
+}
+
+// targetsCollector counts the targets currently known by the Adapter.
+type targetsCollector struct {
+	a *Adapter
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *targetsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- targetsDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *targetsCollector) Collect(ch chan<- prometheus.Metric) {
+	type key struct{ job, zone, state string }
+	counts := make(map[key]int)
+	for _, g := range c.a.Groups() {
+		if len(g.Targets) == 0 {
+			continue
+		}
+		counts[key{g.job, g.Labels[zoneLabel], g.Labels[stateLabel]}] += len(g.Targets)
+	}
+	for k, v := range counts {
+		ch <- prometheus.MustNewConstMetric(targetsDesc, prometheus.GaugeValue, float64(v), k.job, k.zone, k.state)
+	}
 }
 
+// scwLogger is the logger shared by the discovery and the Scaleway API client.
 type scwLogger struct {
 	log.Logger
 }
 
+// newLogger returns a logger writing to w in the given format and dropping
+// the messages below the given level.
+func newLogger(w io.Writer, lvl, format string) *scwLogger {
+	var l log.Logger
+	switch format {
+	case "json":
+		l = log.NewJSONLogger(log.NewSyncWriter(w))
+	default:
+		l = log.NewLogfmtLogger(log.NewSyncWriter(w))
+	}
+
+	var opt level.Option
+	switch lvl {
+	case "debug":
+		opt = level.AllowDebug()
+	case "warn":
+		opt = level.AllowWarn()
+	case "error":
+		opt = level.AllowError()
+	default:
+		opt = level.AllowInfo()
+	}
+	l = level.NewFilter(l, opt)
+
+	return &scwLogger{
+		log.With(l, "ts", log.DefaultTimestampUTC, "caller", log.DefaultCaller),
+	}
+}
+
 // LogHTTP implements the Logger interface of the Scaleway API.
 func (l *scwLogger) LogHTTP(r *http.Request) {
 	level.Debug(l).Log("msg", "HTTP request", "method", r.Method, "url", r.URL.String())
@@ -144,79 +312,262 @@ func (l *scwLogger) Println(v ...interface{}) {
 	level.Error(l).Log("msg", fmt.Sprintln(v...))
 }
 
-// scwDiscoverer retrieves target information from the Scaleway API.
+// targeter returns the target groups of one kind of Scaleway resources.
+type targeter interface {
+	targets() ([]*targetgroup.Group, error)
+}
+
+// scwDiscoverer periodically retrieves the targets of a role from the Scaleway API.
 type scwDiscoverer struct {
+	job      string
+	role     string
+	targeter targeter
+	refresh  int
+	lasts    map[string]struct{}
+	status   *discoveryStatus
+	logger   log.Logger
+	// synced is true once the targets have been sent at least once.
+	synced bool
+}
+
+// serverRole discovers the Scaleway servers.
+type serverRole struct {
 	client    *api.ScalewayAPI
 	port      int
-	refresh   int
 	separator string
-	lasts     map[string]struct{}
 	logger    log.Logger
+
+	bootscripts *bootscriptCache
+	marketplace *marketplaceCache
+
+	// securityGroups is set when the exporter ports must be checked against
+	// the inbound rules of the servers' security groups.
+	securityGroups *securityGroupCache
+	sourceRange    *net.IPNet
+	ports          []int
+
+	// mtx protects servers which caches the result of the last successful
+	// request and excluded which holds the servers left without target by the
+	// last refresh.
+	mtx      sync.RWMutex
+	synced   bool
+	servers  []types.ScalewayServer
+	excluded []excludedServer
+}
+
+// excludedServer is a running server which doesn't produce any target.
+type excludedServer struct {
+	server types.ScalewayServer
+	reason string
 }
 
-func (d *scwDiscoverer) createTarget(srv *types.ScalewayServer) *targetgroup.Group {
+func (r *serverRole) createTarget(srv *types.ScalewayServer) *targetgroup.Group {
 	var tags string
 	if len(srv.Tags) > 0 {
-		tags = d.separator + strings.Join(srv.Tags, d.separator) + d.separator
+		tags = r.separator + strings.Join(srv.Tags, r.separator) + r.separator
 	}
 
-	addr := net.JoinHostPort(srv.PrivateIP, fmt.Sprintf("%d", d.port))
+	addr := net.JoinHostPort(srv.PrivateIP, fmt.Sprintf("%d", r.port))
+
+	labels := model.LabelSet{
+		model.AddressLabel:                      model.LabelValue(addr),
+		model.LabelName(archLabel):              model.LabelValue(srv.Arch),
+		model.LabelName(commercialTypeLabel):    model.LabelValue(srv.CommercialType),
+		model.LabelName(identifierLabel):        model.LabelValue(srv.Identifier),
+		model.LabelName(imageIDLabel):           model.LabelValue(srv.Image.Identifier),
+		model.LabelName(imageNameLabel):         model.LabelValue(srv.Image.Name),
+		model.LabelName(nameLabel):              model.LabelValue(srv.Name),
+		model.LabelName(orgLabel):               model.LabelValue(srv.Organization),
+		model.LabelName(privateIPLabel):         model.LabelValue(srv.PrivateIP),
+		model.LabelName(publicIPLabel):          model.LabelValue(srv.PublicAddress.IP),
+		model.LabelName(stateLabel):             model.LabelValue(srv.State),
+		model.LabelName(tagsLabel):              model.LabelValue(tags),
+		model.LabelName(platformLabel):          model.LabelValue(srv.Location.Platform),
+		model.LabelName(hypervisorLabel):        model.LabelValue(srv.Location.Hypervisor),
+		model.LabelName(nodeLabel):              model.LabelValue(srv.Location.Node),
+		model.LabelName(bladeLabel):             model.LabelValue(srv.Location.Blade),
+		model.LabelName(chassisLabel):           model.LabelValue(srv.Location.Chassis),
+		model.LabelName(clusterLabel):           model.LabelValue(srv.Location.Cluster),
+		model.LabelName(zoneLabel):              model.LabelValue(srv.Location.ZoneID),
+		model.LabelName(securityGroupIDLabel):   model.LabelValue(srv.SecurityGroup.Identifier),
+		model.LabelName(securityGroupNameLabel): model.LabelValue(srv.SecurityGroup.Name),
+	}
 
-	return &targetgroup.Group{
-		Source: fmt.Sprintf("scaleway/%s", srv.Identifier),
-		Targets: []model.LabelSet{
-			model.LabelSet{
-				model.AddressLabel: model.LabelValue(addr),
-			},
-		},
-		Labels: model.LabelSet{
-			model.AddressLabel:                   model.LabelValue(addr),
-			model.LabelName(archLabel):           model.LabelValue(srv.Arch),
-			model.LabelName(commercialTypeLabel): model.LabelValue(srv.CommercialType),
-			model.LabelName(identifierLabel):     model.LabelValue(srv.Identifier),
-			model.LabelName(imageIDLabel):        model.LabelValue(srv.Image.Identifier),
-			model.LabelName(imageNameLabel):      model.LabelValue(srv.Image.Name),
-			model.LabelName(nameLabel):           model.LabelValue(srv.Name),
-			model.LabelName(orgLabel):            model.LabelValue(srv.Organization),
-			model.LabelName(privateIPLabel):      model.LabelValue(srv.PrivateIP),
-			model.LabelName(publicIPLabel):       model.LabelValue(srv.PublicAddress.IP),
-			model.LabelName(stateLabel):          model.LabelValue(srv.State),
-			model.LabelName(tagsLabel):           model.LabelValue(tags),
-			model.LabelName(platformLabel):       model.LabelValue(srv.Location.Platform),
-			model.LabelName(hypervisorLabel):     model.LabelValue(srv.Location.Hypervisor),
-			model.LabelName(nodeLabel):           model.LabelValue(srv.Location.Node),
-			model.LabelName(bladeLabel):          model.LabelValue(srv.Location.Blade),
-			model.LabelName(chassisLabel):        model.LabelValue(srv.Location.Chassis),
-			model.LabelName(clusterLabel):        model.LabelValue(srv.Location.Cluster),
-			model.LabelName(zoneLabel):           model.LabelValue(srv.Location.ZoneID),
+	var (
+		size       uint64
+		lssd, bssd bool
+	)
+	for slot, v := range srv.Volumes {
+		prefix := volumePrefix + strutil.SanitizeLabelName(slot)
+		labels[model.LabelName(prefix+"_id")] = model.LabelValue(v.Identifier)
+		labels[model.LabelName(prefix+"_type")] = model.LabelValue(v.VolumeType)
+		labels[model.LabelName(prefix+"_size_bytes")] = model.LabelValue(strconv.FormatUint(v.Size, 10))
+		size += v.Size
+		switch v.VolumeType {
+		case "l_ssd":
+			lssd = true
+		case "b_ssd":
+			bssd = true
+		}
+	}
+	labels[model.LabelName(volumesCountLabel)] = model.LabelValue(strconv.Itoa(len(srv.Volumes)))
+	labels[model.LabelName(volumesSizeLabel)] = model.LabelValue(strconv.FormatUint(size, 10))
+	labels[model.LabelName(volumesLSSDLabel)] = model.LabelValue(strconv.FormatBool(lssd))
+	labels[model.LabelName(volumesBSSDLabel)] = model.LabelValue(strconv.FormatBool(bssd))
+
+	bootscript := srv.Bootscript
+	if r.bootscripts != nil {
+		b, err := r.bootscripts.resolve(bootscript)
+		if err != nil {
+			level.Warn(r.logger).Log("msg", "failed to get the bootscript", "bootscript", bootscript.Identifier, "err", err)
+		} else {
+			bootscript = b
+		}
+	}
+	var bootscriptID, bootscriptTitle, bootscriptKernel string
+	if bootscript != nil {
+		bootscriptID, bootscriptTitle = bootscript.Identifier, bootscript.Title
+		bootscriptKernel = kernelVersion(bootscript.Kernel, bootscript.Title)
+	}
+	labels[model.LabelName(bootscriptIDLabel)] = model.LabelValue(bootscriptID)
+	labels[model.LabelName(bootscriptTitleLabel)] = model.LabelValue(bootscriptTitle)
+	labels[model.LabelName(bootscriptKernelVersionLabel)] = model.LabelValue(bootscriptKernel)
+
+	var mpID, mpCategories, mpVersion, mpOutdated string
+	if r.marketplace != nil {
+		if mi := r.marketplace.get(srv.Image.Identifier); mi != nil {
+			mpID, mpVersion, mpOutdated = mi.id, mi.version, strconv.FormatBool(mi.outdated)
+			if len(mi.categories) > 0 {
+				mpCategories = r.separator + strings.Join(mi.categories, r.separator) + r.separator
+			}
+		}
+	}
+	labels[model.LabelName(marketplaceIDLabel)] = model.LabelValue(mpID)
+	labels[model.LabelName(marketplaceCategoriesLabel)] = model.LabelValue(mpCategories)
+	labels[model.LabelName(marketplaceVersionLabel)] = model.LabelValue(mpVersion)
+	labels[model.LabelName(marketplaceOutdatedLabel)] = model.LabelValue(mpOutdated)
+
+	targets := []model.LabelSet{
+		model.LabelSet{
+			model.AddressLabel: model.LabelValue(addr),
 		},
 	}
+	if r.securityGroups != nil {
+		var blocked []string
+		targets, blocked = r.checkPorts(srv)
+		// The targets don't share the same address anymore.
+		delete(labels, model.AddressLabel)
+		if len(blocked) > 0 {
+			labels[model.LabelName(blockedPortsLabel)] = model.LabelValue(r.separator + strings.Join(blocked, r.separator) + r.separator)
+		} else {
+			labels[model.LabelName(blockedPortsLabel)] = ""
+		}
+		if len(targets) == 0 {
+			level.Debug(r.logger).Log("msg", "all the exporter ports are blocked by the security group", "server", srv.Identifier, "security_group", srv.SecurityGroup.Identifier)
+		}
+	}
+
+	return &targetgroup.Group{
+		Source:  fmt.Sprintf("scaleway/%s", srv.Identifier),
+		Targets: targets,
+		Labels:  labels,
+	}
 }
 
-func (d *scwDiscoverer) getTargets() ([]*targetgroup.Group, error) {
+// getServers returns the running servers from the Scaleway API.
+func (r *serverRole) getServers() ([]types.ScalewayServer, error) {
 	now := time.Now()
-	srvs, err := d.client.GetServers(false, 0)
+	srvs, err := r.client.GetServers(false, 0)
 	requestDuration.Observe(time.Since(now).Seconds())
 	if err != nil {
 		requestFailures.Inc()
 		return nil, err
 	}
 
-	level.Debug(d.logger).Log("msg", "get servers", "nb", len(*srvs))
+	level.Debug(r.logger).Log("msg", "get servers", "nb", len(*srvs))
+
+	r.mtx.Lock()
+	r.servers = *srvs
+	r.synced = true
+	r.mtx.Unlock()
+	return *srvs, nil
+}
+
+// syncedServers returns the servers retrieved by the last successful request
+// or requests them if no request has succeeded yet. This lets the other roles
+// use the servers before the first refresh of the server role.
+func (r *serverRole) syncedServers() ([]types.ScalewayServer, error) {
+	r.mtx.RLock()
+	srvs, synced := r.servers, r.synced
+	r.mtx.RUnlock()
+	if synced {
+		return srvs, nil
+	}
+	return r.getServers()
+}
+
+// cachedServers returns the servers retrieved by the last successful request.
+func (r *serverRole) cachedServers() []types.ScalewayServer {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.servers
+}
+
+func (r *serverRole) targets() ([]*targetgroup.Group, error) {
+	srvs, err := r.getServers()
+	if err != nil {
+		return nil, err
+	}
+
+	var excluded []excludedServer
+	tgs := make([]*targetgroup.Group, 0, len(srvs))
+	for _, s := range srvs {
+		tg := r.createTarget(&s)
+		if len(tg.Targets) == 0 {
+			reason := "all the exporter ports are blocked by the security group"
+			if blocked := strings.Trim(string(tg.Labels[model.LabelName(blockedPortsLabel)]), r.separator); blocked != "" {
+				reason += " (" + blocked + ")"
+			}
+			excluded = append(excluded, excludedServer{server: s, reason: reason})
+		}
+		tgs = append(tgs, tg)
+	}
+
+	r.mtx.Lock()
+	r.excluded = excluded
+	r.mtx.Unlock()
+	return tgs, nil
+}
+
+// cachedExcluded returns the servers left without target by the last
+// successful refresh.
+func (r *serverRole) cachedExcluded() []excludedServer {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.excluded
+}
+
+func (d *scwDiscoverer) getTargets() ([]*targetgroup.Group, error) {
+	tgs, err := d.targeter.targets()
+	if err != nil {
+		return nil, err
+	}
 
 	current := make(map[string]struct{})
-	tgs := make([]*targetgroup.Group, len(*srvs))
-	for _, s := range *srvs {
-		tg := d.createTarget(&s)
-		level.Debug(d.logger).Log("msg", "server added", "source", tg.Source)
+	for _, tg := range tgs {
+		tg.Labels[model.LabelName(roleLabel)] = model.LabelValue(d.role)
+		if _, ok := d.lasts[tg.Source]; !ok {
+			level.Debug(d.logger).Log("msg", "target added", "role", d.role, "source", tg.Source)
+			targetsAdded.WithLabelValues(d.job).Inc()
+		}
 		current[tg.Source] = struct{}{}
-		tgs = append(tgs, tg)
 	}
 
-	// Add empty groups for servers which have been removed since the last refresh.
+	// Add empty groups for targets which have been removed since the last refresh.
 	for k := range d.lasts {
 		if _, ok := current[k]; !ok {
-			level.Debug(d.logger).Log("msg", "server deleted", "source", k)
+			level.Debug(d.logger).Log("msg", "target deleted", "role", d.role, "source", k)
+			targetsRemoved.WithLabelValues(d.job).Inc()
 			tgs = append(tgs, &targetgroup.Group{Source: k})
 		}
 	}
@@ -227,9 +578,23 @@ func (d *scwDiscoverer) getTargets() ([]*targetgroup.Group, error) {
 
 func (d *scwDiscoverer) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
 	for c := time.Tick(time.Duration(d.refresh) * time.Second); ; {
+		now := time.Now()
 		tgs, err := d.getTargets()
+		refreshDuration.WithLabelValues(d.job).Observe(time.Since(now).Seconds())
+		if d.status != nil {
+			d.status.refreshed(d.job, err)
+		}
 		if err == nil {
+			lastRefreshSuccess.WithLabelValues(d.job).SetToCurrentTime()
+			if len(tgs) == 0 && !d.synced {
+				// The discovery manager ignores empty updates, an empty group
+				// lets the adapter know that the job has no target initially.
+				tgs = []*targetgroup.Group{{Source: d.job}}
+			}
+			d.synced = true
 			ch <- tgs
+		} else {
+			level.Error(d.logger).Log("msg", "failed to refresh targets", "err", err)
 		}
 
 		// Wait for ticker or exit when ctx is closed.
@@ -242,23 +607,31 @@ func (d *scwDiscoverer) Run(ctx context.Context, ch chan<- []*targetgroup.Group)
 	}
 }
 
+// roleJob returns the name of the discovery provider of the given role. The
+// server role keeps the historical name.
+func roleJob(role string) string {
+	if role == "server" {
+		return "scalewaySD"
+	}
+	return "scalewaySD_" + role
+}
+
 func main() {
 	a.HelpFlag.Short('h')
 
 	a.Version(version.Print("prometheus-scw-sd"))
 
-	_, err := a.Parse(os.Args[1:])
+	cmd, err := a.Parse(os.Args[1:])
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	logger := &scwLogger{
-		log.With(
-			log.NewSyncLogger(log.NewLogfmtLogger(os.Stdout)),
-			"ts", log.DefaultTimestampUTC,
-			"caller", log.DefaultCaller,
-		),
+	// Keep the standard output clean when it is used for the command's result.
+	logOutput := os.Stdout
+	if cmd != runCmd.FullCommand() || *outputf == "-" {
+		logOutput = os.Stderr
 	}
+	logger := newLogger(logOutput, *logLevel, *logFormat)
 
 	token := ""
 
@@ -275,6 +648,9 @@ func main() {
 		token = strings.TrimSpace(strings.TrimRight(string(b), "\n"))
 	}
 
+	// The Scaleway client doesn't expose its HTTP client which uses the default transport.
+	http.DefaultTransport = newInstrumentedTransport(http.DefaultTransport)
+
 	client, err := api.NewScalewayAPI(
 		*organization,
 		token,
@@ -290,26 +666,195 @@ func main() {
 	}
 	err = client.CheckCredentials()
 	if err != nil {
-		fmt.Println("failed to check Scaleway credentials:", err)
+		fmt.Fprintln(os.Stderr, "failed to check Scaleway credentials:", err)
 		os.Exit(1)
 	}
 
 	ctx := context.Background()
-	disc := &scwDiscoverer{
-		client:    client,
-		port:      *port,
-		refresh:   *refresh,
-		separator: ",",
-		logger:    logger,
-		lasts:     make(map[string]struct{}),
-	}
-	sdAdapter := NewAdapter(ctx, *outputf, "scalewaySD", disc, logger)
+	servers := &serverRole{
+		client:      client,
+		port:        *port,
+		separator:   ",",
+		logger:      logger,
+		bootscripts: newBootscriptCache(client),
+	}
+
+	if *mpLabels {
+		if *mpCacheTTL <= 0 {
+			fmt.Println("--scw.marketplace.cache-ttl must be positive")
+			os.Exit(1)
+		}
+		servers.marketplace = newMarketplaceCache(client, *mpCacheTTL, logger)
+	}
+
+	if *sgRules {
+		src, err := parseIPRange(*sgSource)
+		if err != nil {
+			fmt.Println("invalid security group source range:", err)
+			os.Exit(1)
+		}
+		servers.securityGroups = newSecurityGroupCache(client, *sgCacheTTL)
+		servers.sourceRange = src
+		servers.ports = *exporterPort
+		if len(servers.ports) == 0 {
+			servers.ports = []int{*port}
+		}
+	}
+
+	if cmd == inventoryCmd.FullCommand() {
+		if err := printAnsibleInventory(os.Stdout, servers, *inventoryHst); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to generate the Ansible inventory:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	serverEnabled := false
+	for _, role := range *roles {
+		if role == "server" {
+			serverEnabled = true
+		}
+	}
+	// The load balancer backends are only resolved to the servers of the
+	// server role when it is enabled.
+	var lbServers *serverRole
+	if serverEnabled {
+		lbServers = servers
+	}
+
+	apiClient := newSCWAPIClient(token)
+	targeters := map[string]targeter{
+		"server": servers,
+		"ip":     &ipRole{client: client, logger: logger},
+		"bucket": &bucketRole{client: client, logger: logger},
+		"lb":     &lbRole{client: apiClient, zones: *zones, separator: servers.separator, servers: lbServers, logger: logger},
+		"kapsule": &kapsuleRole{
+			client:    apiClient,
+			regions:   zonesRegions(*zones),
+			port:      *kapsulePort,
+			apiServer: *kapsuleAPI,
+			separator: servers.separator,
+			servers:   servers,
+			logger:    logger,
+		},
+		"rdb":       &rdbRole{client: apiClient, regions: zonesRegions(*zones), separator: servers.separator, logger: logger},
+		"baremetal": &baremetalRole{client: apiClient, zones: *zones, port: *port, separator: servers.separator, logger: logger},
+		"container": &serverlessRole{client: apiClient, kind: "containers", regions: zonesRegions(*zones), logger: logger},
+		"function":  &serverlessRole{client: apiClient, kind: "functions", regions: zonesRegions(*zones), logger: logger},
+	}
+	discs := make(map[string]*scwDiscoverer)
+	providers := make(map[string]discovery.Discoverer)
+	for _, role := range *roles {
+		if _, ok := discs[role]; ok {
+			continue
+		}
+		d := &scwDiscoverer{
+			job:      roleJob(role),
+			role:     role,
+			targeter: targeters[role],
+			refresh:  *refresh,
+			logger:   logger,
+			lasts:    make(map[string]struct{}),
+		}
+		discs[role] = d
+		providers[d.job] = d
+	}
+
+	if *exportInv {
+		// The inventory is collected from the servers of the server role.
+		if !serverEnabled {
+			fmt.Println("--exporter.inventory requires the server role")
+			os.Exit(1)
+		}
+		reg.MustRegister(&inventoryCollector{r: servers})
+	}
+
+	var outputs []Output
+	for tmplFile, path := range *templates {
+		o, err := newTemplateOutput(tmplFile, path)
+		if err != nil {
+			fmt.Println("failed to load template:", err)
+			os.Exit(1)
+		}
+		outputs = append(outputs, o)
+	}
+	if *kubeCM != "" || *kubeSC {
+		k8s, err := newKubernetesOutput(*kubeconfig, *kubeNS, *kubeCM, *kubeCMKey, *kubeSC, *kubeSCPrefix, logger)
+		if err != nil {
+			fmt.Println("failed to create Kubernetes client:", err)
+			os.Exit(1)
+		}
+		outputs = append(outputs, k8s)
+	}
+
+	sdAdapter := NewAdapter(ctx, *outputf, providers, logger, outputs...)
+	reg.MustRegister(&targetsCollector{a: sdAdapter})
+
+	status := newDiscoveryStatus(time.Duration(*refresh)*time.Second, *readyTol)
+	for _, d := range discs {
+		status.register(d.job)
+		d.status = status
+	}
+	sdAdapter.SetStatus(status)
+	history := newEventHistory(*historySize)
+	sdAdapter.AddListener(history)
+	if *webhookURL != "" {
+		if *webhookQueue < 1 {
+			fmt.Println("--webhook.queue-size must be at least 1")
+			os.Exit(1)
+		}
+		if *webhookRetry < 0 {
+			fmt.Println("--webhook.max-retries can't be negative")
+			os.Exit(1)
+		}
+		webhook := newWebhookNotifier(*webhookURL, *webhookQueue, *webhookRetry, *webhookTmout, logger)
+		sdAdapter.AddListener(webhook)
+		go webhook.Run(ctx)
+	}
+
+	if *once {
+		all := make(map[string][]*targetgroup.Group)
+		for role, d := range discs {
+			tgs, err := d.getTargets()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to get the %s targets from the Scaleway API: %v\n", role, err)
+				os.Exit(1)
+			}
+			all[d.job] = tgs
+		}
+		if err := sdAdapter.WriteOnce(all); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to write targets:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	sdAdapter.Run()
 
+	if *dnsListen != "" {
+		dnsSrv := newDNSServer(sdAdapter, *dnsDomain, *roles, *dnsTTL, *dnsServices, servers.separator, logger)
+		go func() {
+			level.Debug(logger).Log("msg", "listening for DNS queries", "addr", *dnsListen)
+			if err := dnsSrv.ListenAndServe(*dnsListen); err != nil {
+				level.Error(logger).Log("msg", "failed to listen for DNS queries", "addr", *dnsListen, "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	level.Debug(logger).Log("msg", "listening for connections", "addr", *listen)
 	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{ErrorLog: logger}))
-	if err := http.ListenAndServe(*listen, nil); err != nil {
-		level.Debug(logger).Log("msg", "failed to listen", "addr", *listen, "err", err)
+	http.HandleFunc("/-/healthy", status.healthyHandler)
+	http.HandleFunc("/-/ready", status.readyHandler)
+	var serverTargets *serverRole
+	if _, ok := discs["server"]; ok {
+		serverTargets = servers
+	}
+	http.Handle("/targets", targetsHandler(sdAdapter, serverTargets, logger))
+	http.Handle("/api/v1/targets", apiTargetsHandler(sdAdapter, logger))
+	http.Handle("/api/v1/history", apiHistoryHandler(history, logger))
+	if err := newWebServer(*webConfigf, logger).ListenAndServe(*listen, http.DefaultServeMux); err != nil {
+		level.Error(logger).Log("msg", "failed to listen", "addr", *listen, "err", err)
 		os.Exit(1)
 	}
 }
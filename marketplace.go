@@ -0,0 +1,115 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/scaleway/go-scaleway"
+)
+
+// marketplaceRetryInterval is the minimum delay between two attempts to
+// load the marketplace after a failure.
+const marketplaceRetryInterval = time.Minute
+
+// marketplaceImage describes the marketplace image and version from which a
+// local image has been published.
+type marketplaceImage struct {
+	id         string
+	categories []string
+	version    string
+	outdated   bool
+}
+
+// marketplaceCache maps the local images of the servers to the marketplace.
+// The whole marketplace is loaded at once and kept for ttl.
+type marketplaceCache struct {
+	client *api.ScalewayAPI
+	ttl    time.Duration
+	logger log.Logger
+
+	mtx     sync.Mutex
+	images  map[string]*marketplaceImage // indexed by local image ID
+	expires time.Time
+}
+
+func newMarketplaceCache(client *api.ScalewayAPI, ttl time.Duration, logger log.Logger) *marketplaceCache {
+	return &marketplaceCache{
+		client: client,
+		ttl:    ttl,
+		logger: logger,
+	}
+}
+
+// get returns the marketplace image of the given local image, nil if the
+// image doesn't come from the marketplace or if the marketplace can't be loaded.
+func (c *marketplaceCache) get(localImageID string) *marketplaceImage {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if time.Now().After(c.expires) {
+		images, err := c.load()
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "failed to load the marketplace images", "err", err)
+			// Keep the previous images if any and retry later.
+			retry := marketplaceRetryInterval
+			if c.ttl < retry {
+				retry = c.ttl
+			}
+			c.expires = time.Now().Add(retry)
+		} else {
+			c.images = images
+			c.expires = time.Now().Add(c.ttl)
+		}
+	}
+	return c.images[localImageID]
+}
+
+// load indexes the versions of the marketplace images by local image ID.
+func (c *marketplaceCache) load() (map[string]*marketplaceImage, error) {
+	resp, err := c.client.GetMarketPlaceImages("")
+	if err != nil {
+		return nil, err
+	}
+
+	images := make(map[string]*marketplaceImage)
+	for _, img := range resp.Images {
+		current := img.CurrentPublicVersion
+		if current == "" {
+			v, err := c.client.GetMarketPlaceImageCurrentVersion(img.ID)
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "failed to get the current version of the marketplace image", "image", img.ID, "err", err)
+			} else {
+				current = v.Version.ID
+			}
+		}
+
+		for _, v := range img.Versions {
+			mi := &marketplaceImage{
+				id:         img.ID,
+				categories: img.Categories,
+				version:    v.Name,
+				outdated:   current != "" && v.ID != current,
+			}
+			for _, li := range v.LocalImages {
+				images[li.ID] = mi
+			}
+		}
+	}
+	level.Debug(c.logger).Log("msg", "get marketplace images", "nb", len(resp.Images), "local_images", len(images))
+	return images, nil
+}
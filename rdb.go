@@ -0,0 +1,165 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+var (
+	// rdbEngineLabel is the name for the label containing the database engine (eg PostgreSQL).
+	rdbEngineLabel = scwPrefix + "rdb_engine"
+	// rdbVersionLabel is the name for the label containing the version of the database engine.
+	rdbVersionLabel = scwPrefix + "rdb_version"
+	// rdbNodeTypeLabel is the name for the label containing the node type of the database instance.
+	rdbNodeTypeLabel = scwPrefix + "rdb_node_type"
+	// rdbHALabel is the name for the label set to "true" when the database instance is a HA cluster.
+	rdbHALabel = scwPrefix + "rdb_ha"
+	// rdbReadReplicasLabel is the name for the label containing the endpoints of the read replicas.
+	rdbReadReplicasLabel = scwPrefix + "rdb_read_replicas"
+)
+
+// scwRDBEndpoint is an endpoint of a database instance.
+type scwRDBEndpoint struct {
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+}
+
+func (e *scwRDBEndpoint) address() string {
+	return net.JoinHostPort(e.IP, strconv.Itoa(e.Port))
+}
+
+// scwRDBInstance is a database instance returned by the Managed Database API.
+type scwRDBInstance struct {
+	ID           string           `json:"id"`
+	Name         string           `json:"name"`
+	Status       string           `json:"status"`
+	Engine       string           `json:"engine"`
+	Region       string           `json:"region"`
+	Tags         []string         `json:"tags"`
+	Organization string           `json:"organization_id"`
+	NodeType     string           `json:"node_type"`
+	IsHACluster  bool             `json:"is_ha_cluster"`
+	Endpoint     *scwRDBEndpoint  `json:"endpoint"`
+	Endpoints    []scwRDBEndpoint `json:"endpoints"`
+	ReadReplicas []struct {
+		Endpoints []scwRDBEndpoint `json:"endpoints"`
+	} `json:"read_replicas"`
+}
+
+// rdbRole discovers the Managed Database instances. The targets are the
+// endpoints of the instances.
+type rdbRole struct {
+	client    *scwAPIClient
+	regions   []string
+	separator string
+	logger    log.Logger
+}
+
+func (r *rdbRole) getInstances(region string) ([]scwRDBInstance, error) {
+	var instances []scwRDBInstance
+	err := r.client.list(fmt.Sprintf("/rdb/v1/regions/%s/instances", region), func(b json.RawMessage) (int, int, error) {
+		var resp struct {
+			Instances  []scwRDBInstance `json:"instances"`
+			TotalCount int              `json:"total_count"`
+		}
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return 0, 0, err
+		}
+		instances = append(instances, resp.Instances...)
+		return len(resp.Instances), resp.TotalCount, nil
+	})
+	return instances, err
+}
+
+// splitEngine splits the engine returned by the API (eg PostgreSQL-11) into
+// its name and version.
+func splitEngine(engine string) (string, string) {
+	if i := strings.LastIndex(engine, "-"); i > 0 {
+		return engine[:i], engine[i+1:]
+	}
+	return engine, ""
+}
+
+func (r *rdbRole) createTarget(i *scwRDBInstance) *targetgroup.Group {
+	endpoints := i.Endpoints
+	if len(endpoints) == 0 && i.Endpoint != nil {
+		endpoints = []scwRDBEndpoint{*i.Endpoint}
+	}
+	var targets []model.LabelSet
+	for _, e := range endpoints {
+		if e.IP == "" {
+			continue
+		}
+		targets = append(targets, model.LabelSet{
+			model.AddressLabel: model.LabelValue(e.address()),
+		})
+	}
+
+	var replicas []string
+	for _, rr := range i.ReadReplicas {
+		for _, e := range rr.Endpoints {
+			if e.IP != "" {
+				replicas = append(replicas, e.address())
+			}
+		}
+	}
+
+	engine, version := splitEngine(i.Engine)
+	return &targetgroup.Group{
+		Source:  fmt.Sprintf("scaleway/rdb/%s/%s", i.Region, i.ID),
+		Targets: targets,
+		Labels: model.LabelSet{
+			model.LabelName(identifierLabel):      model.LabelValue(i.ID),
+			model.LabelName(nameLabel):            model.LabelValue(i.Name),
+			model.LabelName(orgLabel):             model.LabelValue(i.Organization),
+			model.LabelName(stateLabel):           model.LabelValue(i.Status),
+			model.LabelName(tagsLabel):            model.LabelValue(joinTags(i.Tags, r.separator)),
+			model.LabelName(zoneLabel):            model.LabelValue(i.Region),
+			model.LabelName(rdbEngineLabel):       model.LabelValue(engine),
+			model.LabelName(rdbVersionLabel):      model.LabelValue(version),
+			model.LabelName(rdbNodeTypeLabel):     model.LabelValue(i.NodeType),
+			model.LabelName(rdbHALabel):           model.LabelValue(strconv.FormatBool(i.IsHACluster)),
+			model.LabelName(rdbReadReplicasLabel): model.LabelValue(joinTags(replicas, r.separator)),
+		},
+	}
+}
+
+func (r *rdbRole) targets() ([]*targetgroup.Group, error) {
+	var tgs []*targetgroup.Group
+	for _, region := range r.regions {
+		instances, err := r.getInstances(region)
+		if err != nil {
+			return nil, err
+		}
+		level.Debug(r.logger).Log("msg", "get database instances", "region", region, "nb", len(instances))
+
+		for j := range instances {
+			if instances[j].Region == "" {
+				instances[j].Region = region
+			}
+			tgs = append(tgs, r.createTarget(&instances[j]))
+		}
+	}
+	return tgs, nil
+}
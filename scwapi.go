@@ -0,0 +1,152 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// scwAPIURL is the endpoint of the Scaleway APIs which aren't covered by go-scaleway.
+	scwAPIURL = "https://api.scaleway.com"
+	// scwAPIPageSize is the number of items requested per page.
+	scwAPIPageSize = 100
+)
+
+// scwAPIClient is a minimal client for the zoned and regional Scaleway APIs
+// (Load Balancer, Kubernetes, ...) which go-scaleway doesn't support.
+type scwAPIClient struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newSCWAPIClient(token string) *scwAPIClient {
+	return &scwAPIClient{
+		url:    scwAPIURL,
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// scwAPIError is the error returned by the Scaleway APIs.
+type scwAPIError struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"message"`
+	Type       string `json:"type"`
+}
+
+func (e *scwAPIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("unexpected status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("%s (status code %d)", e.Message, e.StatusCode)
+}
+
+// get decodes the JSON response of a GET request into v.
+func (c *scwAPIClient) get(path string, params url.Values, v interface{}) error {
+	u := c.url + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", c.token)
+	req.Header.Set("User-Agent", "Prometheus/SD-Agent")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &scwAPIError{StatusCode: resp.StatusCode}
+		json.Unmarshal(b, apiErr)
+		return fmt.Errorf("GET %s: %v", path, apiErr)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// list retrieves all the pages of a listing. For every page, decode is
+// called with the response body and returns the number of items of the page
+// and the total number of items.
+func (c *scwAPIClient) list(path string, decode func(b json.RawMessage) (int, int, error)) error {
+	count := 0
+	for page := 1; ; page++ {
+		params := url.Values{}
+		params.Set("page", strconv.Itoa(page))
+		params.Set("page_size", strconv.Itoa(scwAPIPageSize))
+
+		var b json.RawMessage
+		if err := c.get(path, params, &b); err != nil {
+			return err
+		}
+		n, total, err := decode(b)
+		if err != nil {
+			return err
+		}
+		count += n
+		if n == 0 || count >= total {
+			return nil
+		}
+	}
+}
+
+// zoneRegion returns the region of a zone (eg fr-par for fr-par-1).
+func zoneRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		if _, err := strconv.Atoi(zone[i+1:]); err == nil {
+			return zone[:i]
+		}
+	}
+	return zone
+}
+
+// zonesRegions returns the distinct regions of the zones.
+func zonesRegions(zones []string) []string {
+	var regions []string
+	seen := make(map[string]struct{})
+	for _, z := range zones {
+		r := zoneRegion(z)
+		if _, ok := seen[r]; ok {
+			continue
+		}
+		seen[r] = struct{}{}
+		regions = append(regions, r)
+	}
+	return regions
+}
+
+// joinTags returns the tags surrounded by the separator like the tags label
+// of the servers.
+func joinTags(tags []string, separator string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return separator + strings.Join(tags, separator) + separator
+}
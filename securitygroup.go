@@ -0,0 +1,232 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/scaleway/go-scaleway"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+// securityGroupCache caches the rules and the inbound default policy of the
+// security groups for ttl. The failed lookups are cached for ttl too.
+type securityGroupCache struct {
+	client *api.ScalewayAPI
+	ttl    time.Duration
+
+	mtx     sync.Mutex
+	entries map[string]securityGroupEntry
+}
+
+type securityGroupEntry struct {
+	rules         []types.ScalewaySecurityGroupRule
+	defaultPolicy string
+	expires       time.Time
+}
+
+func newSecurityGroupCache(client *api.ScalewayAPI, ttl time.Duration) *securityGroupCache {
+	return &securityGroupCache{
+		client:  client,
+		ttl:     ttl,
+		entries: make(map[string]securityGroupEntry),
+	}
+}
+
+// computeRegions are the regions of the compute API listed by GetServers.
+var computeRegions = []string{"par1", "ams1"}
+
+// computeAPIURL returns the compute API used by go-scaleway for the region.
+func computeAPIURL(region string) string {
+	if u := os.Getenv("SCW_COMPUTE_API"); u != "" {
+		return u
+	}
+	if region == "ams1" {
+		return api.ComputeAPIAms1
+	}
+	return api.ComputeAPIPar1
+}
+
+// computeRequest decodes the response of the compute API of the region for
+// the resource into v. Most of the go-scaleway methods only query the compute
+// API of the client's region.
+func computeRequest(client *api.ScalewayAPI, region, resource string, v interface{}) error {
+	resp, err := client.GetResponsePaginate(computeAPIURL(region), resource, url.Values{})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status code %d", resource, resp.StatusCode)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// lookup returns the rules of the security group sorted by position and its
+// inbound default policy ("accept" or "drop") applied to the inbound traffic
+// matching no rule. The policy isn't part of types.ScalewaySecurityGroups so
+// the group is decoded here.
+func (c *securityGroupCache) lookup(region, id string) ([]types.ScalewaySecurityGroupRule, string, error) {
+	var sg struct {
+		SecurityGroup struct {
+			InboundDefaultPolicy string `json:"inbound_default_policy"`
+		} `json:"security_group"`
+	}
+	if err := computeRequest(c.client, region, "security_groups/"+id, &sg); err != nil {
+		return nil, "", err
+	}
+	var resp types.ScalewayGetSecurityGroupRules
+	if err := computeRequest(c.client, region, "security_groups/"+id+"/rules", &resp); err != nil {
+		return nil, "", err
+	}
+	rules := resp.Rules
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Position < rules[j].Position })
+	return rules, sg.SecurityGroup.InboundDefaultPolicy, nil
+}
+
+// get returns the rules of the given security group of the region sorted by
+// position and its inbound default policy. It returns no rule without error
+// if a recent lookup failed.
+func (c *securityGroupCache) get(region, id string) ([]types.ScalewaySecurityGroupRule, string, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if e, ok := c.entries[id]; ok && time.Now().Before(e.expires) {
+		return e.rules, e.defaultPolicy, nil
+	}
+
+	rules, policy, err := c.lookup(region, id)
+	// A failed lookup is cached without rule.
+	c.entries[id] = securityGroupEntry{rules: rules, defaultPolicy: policy, expires: time.Now().Add(c.ttl)}
+
+	// Forget the security groups which haven't been requested recently.
+	for k, e := range c.entries {
+		if time.Now().After(e.expires.Add(c.ttl)) {
+			delete(c.entries, k)
+		}
+	}
+	return rules, policy, err
+}
+
+// parseIPRange parses a CIDR or a single IP address.
+func parseIPRange(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, &net.ParseError{Type: "IP address", Text: s}
+		}
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+// ruleMatches returns true if the inbound rule applies to the TCP traffic
+// from the whole source range to the port.
+func ruleMatches(r *types.ScalewaySecurityGroupRule, src *net.IPNet, port int) bool {
+	if !strings.EqualFold(r.Direction, "inbound") {
+		return false
+	}
+	if p := strings.ToUpper(r.Protocol); p != "TCP" && p != "ANY" {
+		return false
+	}
+
+	n, err := parseIPRange(r.IPRange)
+	if err != nil {
+		return false
+	}
+	ruleOnes, ruleBits := n.Mask.Size()
+	srcOnes, srcBits := src.Mask.Size()
+	if ruleBits != srcBits || ruleOnes > srcOnes || !n.Contains(src.IP) {
+		return false
+	}
+
+	from, to := r.DestPortFrom, r.DestPortFrom
+	if r.DestPortTo != "" {
+		if v, err := strconv.Atoi(r.DestPortTo); err == nil {
+			to = v
+		}
+	}
+	if from == 0 && to == 0 {
+		// No port means all the ports.
+		return true
+	}
+	return port >= from && port <= to
+}
+
+// portAccepted returns true if the rules accept TCP traffic from the source
+// range to the port. The first matching rule wins and the inbound default
+// policy of the security group applies when no rule matches.
+func portAccepted(rules []types.ScalewaySecurityGroupRule, defaultPolicy string, src *net.IPNet, port int) bool {
+	for i := range rules {
+		if ruleMatches(&rules[i], src, port) {
+			return !strings.EqualFold(rules[i].Action, "drop")
+		}
+	}
+	return !strings.EqualFold(defaultPolicy, "drop")
+}
+
+// checkPorts returns the targets of the exporter ports accepted by the
+// security group of the server and the list of the blocked ports. All the
+// ports are considered as accepted if the rules can't be retrieved, the
+// failure is only logged once per cache TTL.
+func (r *serverRole) checkPorts(srv *types.ScalewayServer) ([]model.LabelSet, []string) {
+	var (
+		rules  []types.ScalewaySecurityGroupRule
+		policy string
+		err    error
+	)
+	if id := srv.SecurityGroup.Identifier; id != "" {
+		rules, policy, err = r.securityGroups.get(srv.Location.ZoneID, id)
+		if err != nil {
+			level.Warn(r.logger).Log("msg", "failed to get the security group rules, assuming that all ports are accepted", "security_group", id, "err", err)
+		}
+	}
+
+	var (
+		targets []model.LabelSet
+		blocked []string
+	)
+	for _, p := range r.ports {
+		if !portAccepted(rules, policy, r.sourceRange, p) {
+			blocked = append(blocked, strconv.Itoa(p))
+			continue
+		}
+		targets = append(targets, model.LabelSet{
+			model.AddressLabel: model.LabelValue(net.JoinHostPort(srv.PrivateIP, strconv.Itoa(p))),
+		})
+	}
+	return targets, blocked
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/scaleway/go-scaleway/types"
+)
+
+func mustParseIPRange(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	n, err := parseIPRange(s)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", s, err)
+	}
+	return n
+}
+
+func TestParseIPRange(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{in: "10.0.0.0/8", want: "10.0.0.0/8"},
+		{in: "10.1.2.3/8", want: "10.0.0.0/8"},
+		{in: "10.1.2.3", want: "10.1.2.3/32"},
+		{in: "2001:db8::1", want: "2001:db8::1/128"},
+		{in: "2001:db8::/32", want: "2001:db8::/32"},
+		{in: "10.1.2", err: true},
+		{in: "10.0.0.0/33", err: true},
+		{in: "", err: true},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			n, err := parseIPRange(tc.in)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n.String() != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, n)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	rule := func(proto, ipRange string, from int, to string) types.ScalewaySecurityGroupRule {
+		return types.ScalewaySecurityGroupRule{
+			Direction:    "inbound",
+			Protocol:     proto,
+			IPRange:      ipRange,
+			DestPortFrom: from,
+			DestPortTo:   to,
+			Action:       "accept",
+		}
+	}
+	outbound := rule("TCP", "0.0.0.0/0", 9100, "")
+	outbound.Direction = "outbound"
+
+	for _, tc := range []struct {
+		name  string
+		rule  types.ScalewaySecurityGroupRule
+		src   string
+		port  int
+		match bool
+	}{
+		{name: "single port", rule: rule("TCP", "0.0.0.0/0", 9100, ""), src: "10.0.0.0/24", port: 9100, match: true},
+		{name: "other port without DestPortTo", rule: rule("TCP", "0.0.0.0/0", 9100, ""), src: "10.0.0.0/24", port: 9101},
+		{name: "inside port range", rule: rule("TCP", "0.0.0.0/0", 9100, "9200"), src: "10.0.0.0/24", port: 9150, match: true},
+		{name: "upper bound of port range", rule: rule("TCP", "0.0.0.0/0", 9100, "9200"), src: "10.0.0.0/24", port: 9200, match: true},
+		{name: "below port range", rule: rule("TCP", "0.0.0.0/0", 9100, "9200"), src: "10.0.0.0/24", port: 9099},
+		{name: "above port range", rule: rule("TCP", "0.0.0.0/0", 9100, "9200"), src: "10.0.0.0/24", port: 9201},
+		{name: "invalid DestPortTo", rule: rule("TCP", "0.0.0.0/0", 9100, "x"), src: "10.0.0.0/24", port: 9100, match: true},
+		{name: "all ports", rule: rule("TCP", "0.0.0.0/0", 0, ""), src: "10.0.0.0/24", port: 9100, match: true},
+		{name: "any protocol", rule: rule("ANY", "0.0.0.0/0", 9100, ""), src: "10.0.0.0/24", port: 9100, match: true},
+		{name: "lower case protocol", rule: rule("tcp", "0.0.0.0/0", 9100, ""), src: "10.0.0.0/24", port: 9100, match: true},
+		{name: "UDP", rule: rule("UDP", "0.0.0.0/0", 9100, ""), src: "10.0.0.0/24", port: 9100},
+		{name: "ICMP", rule: rule("ICMP", "0.0.0.0/0", 0, ""), src: "10.0.0.0/24", port: 9100},
+		{name: "outbound", rule: outbound, src: "10.0.0.0/24", port: 9100},
+		{name: "source inside the rule range", rule: rule("TCP", "10.0.0.0/8", 9100, ""), src: "10.1.0.0/16", port: 9100, match: true},
+		{name: "source equal to the rule range", rule: rule("TCP", "10.0.0.0/8", 9100, ""), src: "10.0.0.0/8", port: 9100, match: true},
+		{name: "single IP rule", rule: rule("TCP", "10.1.2.3", 9100, ""), src: "10.1.2.3/32", port: 9100, match: true},
+		{name: "source partially inside the rule range", rule: rule("TCP", "10.0.0.0/16", 9100, ""), src: "10.0.0.0/8", port: 9100},
+		{name: "source outside the rule range", rule: rule("TCP", "192.168.0.0/16", 9100, ""), src: "10.0.0.0/24", port: 9100},
+		{name: "IPv6 rule and IPv4 source", rule: rule("TCP", "::/0", 9100, ""), src: "10.0.0.0/24", port: 9100},
+		{name: "invalid rule range", rule: rule("TCP", "invalid", 9100, ""), src: "10.0.0.0/24", port: 9100},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ruleMatches(&tc.rule, mustParseIPRange(t, tc.src), tc.port); got != tc.match {
+				t.Fatalf("expected %v, got %v", tc.match, got)
+			}
+		})
+	}
+}
+
+func TestPortAccepted(t *testing.T) {
+	rules := []types.ScalewaySecurityGroupRule{
+		{Direction: "inbound", Protocol: "TCP", IPRange: "10.0.0.0/8", DestPortFrom: 9100, Action: "accept"},
+		{Direction: "inbound", Protocol: "TCP", IPRange: "0.0.0.0/0", DestPortFrom: 9100, DestPortTo: "9200", Action: "drop"},
+		{Direction: "inbound", Protocol: "TCP", IPRange: "0.0.0.0/0", DestPortFrom: 9150, Action: "accept"},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		rules    []types.ScalewaySecurityGroupRule
+		policy   string
+		src      string
+		port     int
+		accepted bool
+	}{
+		{name: "first rule accepts", rules: rules, policy: "drop", src: "10.0.0.0/24", port: 9100, accepted: true},
+		{name: "first matching rule drops", rules: rules, policy: "accept", src: "192.168.0.0/24", port: 9100},
+		{name: "later accept is shadowed", rules: rules, policy: "accept", src: "10.0.0.0/24", port: 9150},
+		{name: "no rule matches with accept policy", rules: rules, policy: "accept", src: "10.0.0.0/24", port: 8080, accepted: true},
+		{name: "no rule matches with drop policy", rules: rules, policy: "drop", src: "10.0.0.0/24", port: 8080},
+		{name: "no rule matches with upper case drop policy", rules: rules, policy: "DROP", src: "10.0.0.0/24", port: 8080},
+		{name: "no rule matches without policy", rules: rules, src: "10.0.0.0/24", port: 8080, accepted: true},
+		{name: "no rules with drop policy", policy: "drop", src: "10.0.0.0/24", port: 9100},
+		{name: "no rules with accept policy", policy: "accept", src: "10.0.0.0/24", port: 9100, accepted: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := portAccepted(tc.rules, tc.policy, mustParseIPRange(t, tc.src), tc.port); got != tc.accepted {
+				t.Fatalf("expected %v, got %v", tc.accepted, got)
+			}
+		})
+	}
+}
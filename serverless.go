@@ -0,0 +1,192 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+var (
+	// serverlessNamespaceIDLabel is the name for the label containing the ID of the namespace.
+	serverlessNamespaceIDLabel = scwPrefix + "serverless_namespace_id"
+	// serverlessNamespaceNameLabel is the name for the label containing the name of the namespace.
+	serverlessNamespaceNameLabel = scwPrefix + "serverless_namespace_name"
+	// serverlessRuntimeLabel is the name for the label containing the runtime of the function.
+	serverlessRuntimeLabel = scwPrefix + "serverless_runtime"
+	// serverlessImageLabel is the name for the label containing the registry image of the container.
+	serverlessImageLabel = scwPrefix + "serverless_registry_image"
+	// serverlessMinScaleLabel is the name for the label containing the minimum number of instances.
+	serverlessMinScaleLabel = scwPrefix + "serverless_min_scale"
+	// serverlessMaxScaleLabel is the name for the label containing the maximum number of instances.
+	serverlessMaxScaleLabel = scwPrefix + "serverless_max_scale"
+	// serverlessPrivacyLabel is the name for the label containing the privacy setting (public or private).
+	serverlessPrivacyLabel = scwPrefix + "serverless_privacy"
+	// serverlessEndpointLabel is the name for the label containing the public endpoint URL.
+	serverlessEndpointLabel = scwPrefix + "serverless_endpoint"
+)
+
+// scwServerlessNamespace is a namespace returned by the Serverless APIs.
+type scwServerlessNamespace struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Organization string `json:"organization_id"`
+}
+
+// scwServerlessApp is a container or a function returned by the Serverless
+// APIs. Runtime is only set for functions and RegistryImage for containers.
+type scwServerlessApp struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	NamespaceID   string `json:"namespace_id"`
+	Status        string `json:"status"`
+	Runtime       string `json:"runtime"`
+	RegistryImage string `json:"registry_image"`
+	MinScale      int    `json:"min_scale"`
+	MaxScale      int    `json:"max_scale"`
+	Privacy       string `json:"privacy"`
+	DomainName    string `json:"domain_name"`
+	Region        string `json:"region"`
+}
+
+// serverlessRole discovers the Serverless Containers or Functions depending
+// on kind ("containers" or "functions"). The targets are the public endpoint
+// URLs which change on every redeploy.
+type serverlessRole struct {
+	client  *scwAPIClient
+	kind    string
+	regions []string
+	logger  log.Logger
+}
+
+func (r *serverlessRole) getNamespaces(region string) ([]scwServerlessNamespace, error) {
+	var namespaces []scwServerlessNamespace
+	err := r.client.list(fmt.Sprintf("/%s/v1beta1/regions/%s/namespaces", r.kind, region), func(b json.RawMessage) (int, int, error) {
+		var resp struct {
+			Namespaces []scwServerlessNamespace `json:"namespaces"`
+			TotalCount int                      `json:"total_count"`
+		}
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return 0, 0, err
+		}
+		namespaces = append(namespaces, resp.Namespaces...)
+		return len(resp.Namespaces), resp.TotalCount, nil
+	})
+	return namespaces, err
+}
+
+// getApps returns the containers or functions of all the namespaces of the
+// region.
+func (r *serverlessRole) getApps(region string) ([]scwServerlessApp, error) {
+	var apps []scwServerlessApp
+	err := r.client.list(fmt.Sprintf("/%s/v1beta1/regions/%s/%s", r.kind, region, r.kind), func(b json.RawMessage) (int, int, error) {
+		// The items are under the "containers" or "functions" key.
+		var resp map[string]json.RawMessage
+		if err := json.Unmarshal(b, &resp); err != nil {
+			return 0, 0, err
+		}
+		var page []scwServerlessApp
+		if items, ok := resp[r.kind]; ok {
+			if err := json.Unmarshal(items, &page); err != nil {
+				return 0, 0, err
+			}
+		}
+		var total int
+		if tc, ok := resp["total_count"]; ok {
+			if err := json.Unmarshal(tc, &total); err != nil {
+				return 0, 0, err
+			}
+		}
+		apps = append(apps, page...)
+		return len(page), total, nil
+	})
+	return apps, err
+}
+
+func (r *serverlessRole) createTarget(ns *scwServerlessNamespace, app *scwServerlessApp) *targetgroup.Group {
+	labels := model.LabelSet{
+		model.LabelName(identifierLabel):              model.LabelValue(app.ID),
+		model.LabelName(nameLabel):                    model.LabelValue(app.Name),
+		model.LabelName(orgLabel):                     model.LabelValue(ns.Organization),
+		model.LabelName(stateLabel):                   model.LabelValue(app.Status),
+		model.LabelName(zoneLabel):                    model.LabelValue(app.Region),
+		model.LabelName(serverlessNamespaceIDLabel):   model.LabelValue(ns.ID),
+		model.LabelName(serverlessNamespaceNameLabel): model.LabelValue(ns.Name),
+		model.LabelName(serverlessRuntimeLabel):       model.LabelValue(app.Runtime),
+		model.LabelName(serverlessImageLabel):         model.LabelValue(app.RegistryImage),
+		model.LabelName(serverlessMinScaleLabel):      model.LabelValue(strconv.Itoa(app.MinScale)),
+		model.LabelName(serverlessMaxScaleLabel):      model.LabelValue(strconv.Itoa(app.MaxScale)),
+		model.LabelName(serverlessPrivacyLabel):       model.LabelValue(app.Privacy),
+	}
+
+	// The domain is only known once the container or function is deployed.
+	var (
+		endpoint string
+		targets  []model.LabelSet
+	)
+	if app.DomainName != "" {
+		endpoint = "https://" + app.DomainName
+		labels[model.AddressLabel] = model.LabelValue(endpoint)
+		targets = append(targets, model.LabelSet{
+			model.AddressLabel: model.LabelValue(endpoint),
+		})
+	}
+	labels[model.LabelName(serverlessEndpointLabel)] = model.LabelValue(endpoint)
+
+	return &targetgroup.Group{
+		Source:  fmt.Sprintf("scaleway/%s/%s/%s", r.kind, app.Region, app.ID),
+		Targets: targets,
+		Labels:  labels,
+	}
+}
+
+func (r *serverlessRole) targets() ([]*targetgroup.Group, error) {
+	var tgs []*targetgroup.Group
+	for _, region := range r.regions {
+		namespaces, err := r.getNamespaces(region)
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[string]*scwServerlessNamespace, len(namespaces))
+		for i := range namespaces {
+			byID[namespaces[i].ID] = &namespaces[i]
+		}
+
+		apps, err := r.getApps(region)
+		if err != nil {
+			return nil, err
+		}
+		level.Debug(r.logger).Log("msg", "get serverless "+r.kind, "region", region, "namespaces", len(namespaces), "nb", len(apps))
+
+		for i := range apps {
+			app := &apps[i]
+			if app.Region == "" {
+				app.Region = region
+			}
+			ns, ok := byID[app.NamespaceID]
+			if !ok {
+				// The namespace has been created between both requests.
+				ns = &scwServerlessNamespace{ID: app.NamespaceID}
+			}
+			tgs = append(tgs, r.createTarget(ns, app))
+		}
+	}
+	return tgs, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the helper functions available to the output templates.
+var templateFuncs = template.FuncMap{
+	"join":      strings.Join,
+	"split":     strings.Split,
+	"replace":   strings.Replace,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"trim":      strings.Trim,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"contains":  strings.Contains,
+	// tags returns the list of tags from the value of the tags label.
+	"tags": func(s string) []string {
+		s = strings.Trim(s, ",")
+		if s == "" {
+			return nil
+		}
+		return strings.Split(s, ",")
+	},
+}
+
+// templateTarget is a single target passed to the output templates.
+type templateTarget struct {
+	Address string
+	Job     string
+	Labels  map[string]string
+}
+
+// templateData is the data passed to the output templates.
+type templateData struct {
+	// Groups holds the target groups as written to the file_sd file.
+	Groups []customSD
+	// Targets holds one entry per target with the labels of its group.
+	Targets []templateTarget
+}
+
+// templateOutput renders the target groups with a user-supplied text/template
+// and writes the result to a file.
+type templateOutput struct {
+	tmpl *template.Template
+	path string
+}
+
+func newTemplateOutput(tmplFile, path string) (*templateOutput, error) {
+	tmpl, err := template.New(filepath.Base(tmplFile)).Funcs(templateFuncs).ParseFiles(tmplFile)
+	if err != nil {
+		return nil, err
+	}
+	return &templateOutput{tmpl: tmpl, path: path}, nil
+}
+
+// Write implements the Output interface. The file is only replaced if the
+// template renders successfully.
+func (o *templateOutput) Write(groups []customSD) error {
+	data := templateData{Groups: groups}
+	for _, g := range groups {
+		for _, t := range g.Targets {
+			data.Targets = append(data.Targets, templateTarget{Address: t, Job: g.job, Labels: g.Labels})
+		}
+	}
+	sort.Slice(data.Targets, func(i, j int) bool {
+		return data.Targets[i].Address < data.Targets[j].Address
+	})
+
+	var b bytes.Buffer
+	if err := o.tmpl.Execute(&b, data); err != nil {
+		return fmt.Errorf("failed to render template %s: %v", o.tmpl.Name(), err)
+	}
+	return writeFileAtomic(o.path, b.Bytes())
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/scaleway/go-scaleway"
+)
+
+var (
+	apiRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "prometheus_scaleway_sd_api_request_duration_seconds",
+			Help:    "Histogram of latencies for HTTP requests to the Scaleway API.",
+			Buckets: []float64{0.001, 0.01, 0.1, 0.5, 1.0, 2.0, 5.0, 10.0},
+		},
+		[]string{"zone", "resource", "method", "code"},
+	)
+	apiRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "prometheus_scaleway_sd_api_requests_total",
+			Help: "Total number of HTTP requests to the Scaleway API.",
+		},
+		[]string{"zone", "resource", "method", "code"},
+	)
+	apiTotalCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "prometheus_scaleway_sd_api_total_count",
+			Help: "Last value of the X-Total-Count header returned by the Scaleway API.",
+		},
+		[]string{"zone", "resource"},
+	)
+)
+
+func init() {
+	reg.MustRegister(apiRequestDuration)
+	reg.MustRegister(apiRequests)
+	reg.MustRegister(apiTotalCount)
+}
+
+// instrumentedTransport is a http.RoundTripper recording metrics about the
+// requests to the Scaleway API.
+type instrumentedTransport struct {
+	next http.RoundTripper
+	// zones maps the host of the compute APIs to their zone.
+	zones map[string]string
+}
+
+// newInstrumentedTransport returns an instrumented version of the given transport.
+func newInstrumentedTransport(next http.RoundTripper) *instrumentedTransport {
+	zones := make(map[string]string)
+	for zone, u := range map[string]string{"par1": api.ComputeAPIPar1, "ams1": api.ComputeAPIAms1} {
+		if pu, err := url.Parse(u); err == nil {
+			zones[pu.Host] = zone
+		}
+	}
+	return &instrumentedTransport{next: next, zones: zones}
+}
+
+// requestLabels returns the zone and the resource of a request. The paths of
+// the zoned and regional APIs (/<product>/<version>/zones/<zone>/<resource>/...)
+// give the zone (or region) and the resource is made of the product and of
+// the collections without the identifiers (eg lb/lbs/frontends). For the
+// other APIs, the zone comes from the host and the resource is the first
+// segment of the path.
+func (t *instrumentedTransport) requestLabels(u *url.URL) (string, string) {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) >= 5 && (segments[2] == "zones" || segments[2] == "regions") {
+		resource := segments[0]
+		for i := 4; i < len(segments); i += 2 {
+			resource += "/" + segments[i]
+		}
+		return segments[3], resource
+	}
+
+	zone, ok := t.zones[u.Host]
+	if !ok {
+		zone = "global"
+	}
+	return zone, segments[0]
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *instrumentedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	zone, resource := t.requestLabels(r.URL)
+
+	now := time.Now()
+	resp, err := t.next.RoundTrip(r)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+		if c, err := strconv.Atoi(resp.Header.Get("X-Total-Count")); err == nil {
+			apiTotalCount.WithLabelValues(zone, resource).Set(float64(c))
+		}
+	}
+	apiRequestDuration.WithLabelValues(zone, resource, r.Method, code).Observe(time.Since(now).Seconds())
+	apiRequests.WithLabelValues(zone, resource, r.Method, code).Inc()
+
+	return resp, err
+}
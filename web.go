@@ -0,0 +1,211 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+var targetsTemplate = template.Must(template.New("targets").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Scaleway SD targets</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f5f5f5; }
+.label { display: inline-block; background: #eef; border-radius: 3px; margin: 1px; padding: 0 4px; font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>{{ if .Excluded }}Excluded servers{{ else }}Targets{{ end }}</h1>
+<form method="get">
+<input type="text" name="q" value="{{ .Query }}" placeholder="Filter by address, source or label" size="50">
+{{ if .Excluded }}<input type="hidden" name="excluded" value="1">{{ end }}
+<input type="submit" value="Search">
+</form>
+{{ if .Excluded }}<p><a href="?q={{ .Query }}">Show the targets</a></p>
+{{ if .Error }}<p>No excluded servers: {{ .Error }}</p>
+{{ else }}<p>{{ len .Servers }} server(s) without target.</p>
+<table>
+<tr><th>Identifier</th><th>Name</th><th>State</th><th>Zone</th><th>Reason</th></tr>
+{{ range .Servers }}<tr>
+<td>{{ .ID }}</td>
+<td>{{ .Name }}</td>
+<td>{{ .State }}</td>
+<td>{{ .Zone }}</td>
+<td>{{ .Reason }}</td>
+</tr>
+{{ end }}</table>
+{{ end }}{{ else }}<p><a href="?excluded=1&amp;q={{ .Query }}">Show the excluded servers</a></p>
+<p>{{ len .Groups }} target group(s).</p>
+<table>
+<tr><th>Job</th><th>Source</th><th>Targets</th><th>Labels</th><th>First seen</th><th>Last seen</th></tr>
+{{ range .Groups }}<tr>
+<td>{{ .Job }}</td>
+<td>{{ .Source }}</td>
+<td>{{ range .Targets }}{{ . }}<br>{{ end }}</td>
+<td>{{ range .Labels }}<span class="label">{{ .Name }}="{{ .Value }}"</span> {{ end }}</td>
+<td>{{ .FirstSeen }}</td>
+<td>{{ .LastSeen }}</td>
+</tr>
+{{ end }}</table>
+{{ end }}</body>
+</html>
+`))
+
+type webLabel struct {
+	Name  string
+	Value string
+}
+
+type webGroup struct {
+	Job       string
+	Source    string
+	Targets   []string
+	Labels    []webLabel
+	FirstSeen string
+	LastSeen  string
+}
+
+// matches returns true if the query is found in the address, the source or
+// one of the labels of the group.
+func (g *webGroup) matches(q string) bool {
+	if q == "" {
+		return true
+	}
+	q = strings.ToLower(q)
+	candidates := append([]string{g.Job, g.Source}, g.Targets...)
+	for _, l := range g.Labels {
+		candidates = append(candidates, l.Name+"="+l.Value)
+	}
+	for _, c := range candidates {
+		if strings.Contains(strings.ToLower(c), q) {
+			return true
+		}
+	}
+	return false
+}
+
+// webExcludedServer is a server which doesn't produce any target.
+type webExcludedServer struct {
+	ID     string
+	Name   string
+	State  string
+	Zone   string
+	Reason string
+}
+
+func (s *webExcludedServer) matches(q string) bool {
+	q = strings.ToLower(q)
+	for _, c := range []string{s.ID, s.Name, s.State, s.Zone, s.Reason} {
+		if strings.Contains(strings.ToLower(c), q) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludedServers returns the running servers which were left without
+// target by the last refresh of the server role, ie the ones whose exporter
+// ports are all blocked by their security group.
+func excludedServers(r *serverRole, q string) []*webExcludedServer {
+	var servers []*webExcludedServer
+	for _, e := range r.cachedExcluded() {
+		s := &webExcludedServer{
+			ID:     e.server.Identifier,
+			Name:   e.server.Name,
+			State:  e.server.State,
+			Zone:   e.server.Location.ZoneID,
+			Reason: e.reason,
+		}
+		if s.matches(q) {
+			servers = append(servers, s)
+		}
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	return servers
+}
+
+// targetsHandler renders the target groups currently known by the Adapter.
+// With the excluded parameter, it lists the servers left without target by
+// the server role instead (servers is nil when the role isn't enabled).
+func targetsHandler(a *Adapter, servers *serverRole, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+
+		if r.URL.Query().Get("excluded") != "" {
+			data := struct {
+				Query    string
+				Excluded bool
+				Servers  []*webExcludedServer
+				Error    string
+			}{Query: q, Excluded: true}
+			if servers == nil {
+				data.Error = "the server role isn't enabled"
+			} else {
+				data.Servers = excludedServers(servers, q)
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if err := targetsTemplate.Execute(w, data); err != nil {
+				level.Error(logger).Log("msg", "failed to render the excluded servers page", "err", err)
+			}
+			return
+		}
+
+		var groups []*webGroup
+		for _, g := range a.Groups() {
+			if len(g.Targets) == 0 {
+				continue
+			}
+			first, last := a.Seen(g.job, g.source)
+			wg := &webGroup{
+				Job:       g.job,
+				Source:    g.source,
+				Targets:   g.Targets,
+				FirstSeen: first.UTC().Format(time.RFC3339),
+				LastSeen:  last.UTC().Format(time.RFC3339),
+			}
+			for k, v := range g.Labels {
+				wg.Labels = append(wg.Labels, webLabel{Name: k, Value: v})
+			}
+			sort.Slice(wg.Labels, func(i, j int) bool { return wg.Labels[i].Name < wg.Labels[j].Name })
+			if wg.matches(q) {
+				groups = append(groups, wg)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := targetsTemplate.Execute(w, struct {
+			Query    string
+			Excluded bool
+			Groups   []*webGroup
+		}{
+			Query:  q,
+			Groups: groups,
+		})
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to render the targets page", "err", err)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// webConfig is the configuration of the web listener. It follows the format
+// of the Prometheus exporter-toolkit web configuration file.
+type webConfig struct {
+	TLSConfig  tlsServerConfig   `yaml:"tls_server_config"`
+	HTTPConfig httpServerConfig  `yaml:"http_server_config"`
+	Users      map[string]string `yaml:"basic_auth_users"`
+}
+
+type tlsServerConfig struct {
+	CertFile                 string   `yaml:"cert_file"`
+	KeyFile                  string   `yaml:"key_file"`
+	ClientAuth               string   `yaml:"client_auth_type"`
+	ClientCAs                string   `yaml:"client_ca_file"`
+	CipherSuites             []string `yaml:"cipher_suites"`
+	CurvePreferences         []string `yaml:"curve_preferences"`
+	MinVersion               string   `yaml:"min_version"`
+	MaxVersion               string   `yaml:"max_version"`
+	PreferServerCipherSuites bool     `yaml:"prefer_server_cipher_suites"`
+}
+
+type httpServerConfig struct {
+	HTTP2   bool              `yaml:"http2"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"TLS10": tls.VersionTLS10,
+		"TLS11": tls.VersionTLS11,
+		"TLS12": tls.VersionTLS12,
+		"TLS13": tls.VersionTLS13,
+	}
+	tlsCurves = map[string]tls.CurveID{
+		"CurveP256": tls.CurveP256,
+		"CurveP384": tls.CurveP384,
+		"CurveP521": tls.CurveP521,
+		"X25519":    tls.X25519,
+	}
+	tlsClientAuthTypes = map[string]tls.ClientAuthType{
+		"":                           tls.NoClientCert,
+		"NoClientCert":               tls.NoClientCert,
+		"RequestClientCert":          tls.RequestClientCert,
+		"RequireAnyClientCert":       tls.RequireAnyClientCert,
+		"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+		"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+	}
+)
+
+// loadWebConfig reads the web configuration file. The relative
+// paths of the file are resolved against the directory of the file.
+func loadWebConfig(filename string) (*webConfig, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c := &webConfig{
+		TLSConfig:  tlsServerConfig{MinVersion: "TLS12"},
+		HTTPConfig: httpServerConfig{HTTP2: true},
+	}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(filename)
+	for _, p := range []*string{&c.TLSConfig.CertFile, &c.TLSConfig.KeyFile, &c.TLSConfig.ClientCAs} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	return c, nil
+}
+
+// enabled returns true if the listener should serve TLS.
+func (c *tlsServerConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// config returns the TLS configuration, nil if TLS isn't enabled.
+func (c *tlsServerConfig) config() (*tls.Config, error) {
+	if !c.enabled() {
+		if c.ClientCAs != "" || c.ClientAuth != "" {
+			return nil, errors.New("client authentication requires cert_file and key_file")
+		}
+		return nil, nil
+	}
+	if c.CertFile == "" {
+		return nil, errors.New("missing cert_file")
+	}
+	if c.KeyFile == "" {
+		return nil, errors.New("missing key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the X509 key pair: %v", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		PreferServerCipherSuites: c.PreferServerCipherSuites,
+	}
+
+	var ok bool
+	if cfg.MinVersion, ok = tlsVersions[c.MinVersion]; !ok {
+		return nil, fmt.Errorf("unknown TLS version %q", c.MinVersion)
+	}
+	if c.MaxVersion != "" {
+		if cfg.MaxVersion, ok = tlsVersions[c.MaxVersion]; !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", c.MaxVersion)
+		}
+	}
+
+	if len(c.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[s.Name] = s.ID
+		}
+		for _, name := range c.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown cipher suite %q", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+	for _, name := range c.CurvePreferences {
+		id, ok := tlsCurves[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		cfg.CurvePreferences = append(cfg.CurvePreferences, id)
+	}
+
+	if cfg.ClientAuth, ok = tlsClientAuthTypes[c.ClientAuth]; !ok {
+		return nil, fmt.Errorf("unknown client_auth_type %q", c.ClientAuth)
+	}
+	if c.ClientCAs != "" {
+		b, err := ioutil.ReadFile(c.ClientCAs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the client CA file: %v", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in %s", c.ClientCAs)
+		}
+	} else if cfg.ClientAuth == tls.VerifyClientCertIfGiven || cfg.ClientAuth == tls.RequireAndVerifyClientCert {
+		return nil, errors.New("client_ca_file is required to verify the client certificates")
+	}
+
+	return cfg, nil
+}
+
+// webServer serves HTTP with the TLS and authentication settings of the web
+// configuration file. The file is read again for every TLS handshake and
+// every request so that certificates and users can be changed without restart.
+type webServer struct {
+	filename string
+	logger   log.Logger
+
+	mtx sync.Mutex
+	// authenticated caches the successful bcrypt comparisons which are slow
+	// by design.
+	authenticated map[[sha256.Size]byte]struct{}
+}
+
+func newWebServer(filename string, logger log.Logger) *webServer {
+	return &webServer{
+		filename:      filename,
+		logger:        logger,
+		authenticated: make(map[[sha256.Size]byte]struct{}),
+	}
+}
+
+// ListenAndServe listens on the given address and serves the handler.
+func (s *webServer) ListenAndServe(addr string, handler http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	if s.filename == "" {
+		return srv.ListenAndServe()
+	}
+
+	c, err := loadWebConfig(s.filename)
+	if err != nil {
+		return fmt.Errorf("failed to load the web configuration: %v", err)
+	}
+	if _, err := c.TLSConfig.config(); err != nil {
+		return fmt.Errorf("invalid TLS configuration: %v", err)
+	}
+	srv.Handler = s.wrap(handler)
+	if !c.TLSConfig.enabled() {
+		return srv.ListenAndServe()
+	}
+
+	if !c.HTTPConfig.HTTP2 {
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	srv.TLSConfig = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c, err := loadWebConfig(s.filename)
+			if err != nil {
+				level.Error(s.logger).Log("msg", "failed to load the web configuration", "err", err)
+				return nil, err
+			}
+			if !c.TLSConfig.enabled() {
+				return nil, errors.New("TLS can't be disabled without restart")
+			}
+			cfg, err := c.TLSConfig.config()
+			if err != nil {
+				return nil, err
+			}
+			if c.HTTPConfig.HTTP2 {
+				cfg.NextProtos = []string{"h2", "http/1.1"}
+			}
+			return cfg, nil
+		},
+	}
+	level.Info(s.logger).Log("msg", "TLS is enabled", "file", s.filename)
+	return srv.ListenAndServeTLS("", "")
+}
+
+// wrap returns a handler setting the configured headers and checking the
+// basic authentication of the requests.
+func (s *webServer) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := loadWebConfig(s.filename)
+		if err != nil {
+			level.Error(s.logger).Log("msg", "failed to load the web configuration", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		for k, v := range c.HTTPConfig.Headers {
+			w.Header().Set(k, v)
+		}
+
+		if len(c.Users) > 0 {
+			user, pass, ok := r.BasicAuth()
+			if !ok || !s.authenticate(c.Users, user, pass) {
+				w.Header().Set("WWW-Authenticate", "Basic")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns true if the password matches the bcrypt hash of the user.
+func (s *webServer) authenticate(users map[string]string, user, pass string) bool {
+	hash, ok := users[user]
+	if !ok {
+		// Compare against a dummy hash to not reveal the existing users
+		// through the response time.
+		hash = "$2y$10$QOauhQNbBCuQDKes6eFzPeMqBSjb7Mr5DUmpZ/VcEd00UAV/LDeSi"
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + hash + "\x00" + pass))
+	s.mtx.Lock()
+	_, cached := s.authenticated[key]
+	s.mtx.Unlock()
+	if cached {
+		return ok
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil || !ok {
+		return false
+	}
+	s.mtx.Lock()
+	s.authenticated[key] = struct{}{}
+	s.mtx.Unlock()
+	return true
+}
@@ -0,0 +1,384 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func mustHash(t *testing.T, pass string) string {
+	t.Helper()
+	h, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h)
+}
+
+// writeKeyPair writes a self-signed certificate and its key to dir.
+func writeKeyPair(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestAuthenticate(t *testing.T) {
+	users := map[string]string{
+		"alice": mustHash(t, "secret"),
+		"bob":   mustHash(t, "hunter2"),
+	}
+
+	for _, tc := range []struct {
+		name string
+		user string
+		pass string
+		ok   bool
+	}{
+		{name: "valid password", user: "alice", pass: "secret", ok: true},
+		{name: "other user", user: "bob", pass: "hunter2", ok: true},
+		{name: "wrong password", user: "alice", pass: "wrong"},
+		{name: "password of another user", user: "alice", pass: "hunter2"},
+		{name: "empty password", user: "alice"},
+		{name: "unknown user", user: "mallory", pass: "secret"},
+		{name: "empty user", pass: "secret"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newWebServer("", log.NewNopLogger())
+			// The second call goes through the cache of the successful comparisons.
+			for i := 0; i < 2; i++ {
+				if got := s.authenticate(users, tc.user, tc.pass); got != tc.ok {
+					t.Fatalf("attempt %d: expected %v, got %v", i+1, tc.ok, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticateCacheAfterPasswordChange(t *testing.T) {
+	s := newWebServer("", log.NewNopLogger())
+	users := map[string]string{"alice": mustHash(t, "old")}
+	if !s.authenticate(users, "alice", "old") {
+		t.Fatal("expected the old password to be accepted")
+	}
+
+	users["alice"] = mustHash(t, "new")
+	if s.authenticate(users, "alice", "old") {
+		t.Fatal("expected the old password to be rejected after the change")
+	}
+	if !s.authenticate(users, "alice", "new") {
+		t.Fatal("expected the new password to be accepted")
+	}
+
+	delete(users, "alice")
+	if s.authenticate(users, "alice", "new") {
+		t.Fatal("expected the password of a removed user to be rejected")
+	}
+}
+
+func TestTLSServerConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeKeyPair(t, dir)
+
+	for _, tc := range []struct {
+		name  string
+		cfg   tlsServerConfig
+		err   bool
+		check func(*testing.T, *tls.Config)
+	}{
+		{
+			name: "TLS disabled",
+			cfg:  tlsServerConfig{MinVersion: "TLS12"},
+			check: func(t *testing.T, c *tls.Config) {
+				if c != nil {
+					t.Fatalf("expected no TLS configuration, got %v", c)
+				}
+			},
+		},
+		{
+			name: "defaults",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "TLS12"},
+			check: func(t *testing.T, c *tls.Config) {
+				if c.MinVersion != tls.VersionTLS12 || c.MaxVersion != 0 {
+					t.Fatalf("unexpected versions %x-%x", c.MinVersion, c.MaxVersion)
+				}
+				if c.ClientAuth != tls.NoClientCert {
+					t.Fatalf("unexpected client auth %v", c.ClientAuth)
+				}
+				if len(c.Certificates) != 1 {
+					t.Fatalf("expected 1 certificate, got %d", len(c.Certificates))
+				}
+			},
+		},
+		{
+			name: "client auth without certificate",
+			cfg:  tlsServerConfig{ClientAuth: "RequireAndVerifyClientCert", MinVersion: "TLS12"},
+			err:  true,
+		},
+		{
+			name: "client CA without certificate",
+			cfg:  tlsServerConfig{ClientCAs: certFile, MinVersion: "TLS12"},
+			err:  true,
+		},
+		{
+			name: "missing key file",
+			cfg:  tlsServerConfig{CertFile: certFile, MinVersion: "TLS12"},
+			err:  true,
+		},
+		{
+			name: "missing cert file",
+			cfg:  tlsServerConfig{KeyFile: keyFile, MinVersion: "TLS12"},
+			err:  true,
+		},
+		{
+			name: "RequireAndVerifyClientCert without CA",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: "RequireAndVerifyClientCert", MinVersion: "TLS12"},
+			err:  true,
+		},
+		{
+			name: "VerifyClientCertIfGiven without CA",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: "VerifyClientCertIfGiven", MinVersion: "TLS12"},
+			err:  true,
+		},
+		{
+			name: "RequireAnyClientCert without CA",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: "RequireAnyClientCert", MinVersion: "TLS12"},
+			check: func(t *testing.T, c *tls.Config) {
+				if c.ClientAuth != tls.RequireAnyClientCert {
+					t.Fatalf("unexpected client auth %v", c.ClientAuth)
+				}
+			},
+		},
+		{
+			name: "RequireAndVerifyClientCert with CA",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: "RequireAndVerifyClientCert", ClientCAs: certFile, MinVersion: "TLS12"},
+			check: func(t *testing.T, c *tls.Config) {
+				if c.ClientAuth != tls.RequireAndVerifyClientCert {
+					t.Fatalf("unexpected client auth %v", c.ClientAuth)
+				}
+				if c.ClientCAs == nil {
+					t.Fatal("expected client CAs")
+				}
+			},
+		},
+		{
+			name: "client CA file without certificate",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: "RequireAndVerifyClientCert", ClientCAs: keyFile, MinVersion: "TLS12"},
+			err:  true,
+		},
+		{
+			name: "unknown client auth type",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: "Whatever", MinVersion: "TLS12"},
+			err:  true,
+		},
+		{
+			name: "TLS versions",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "TLS11", MaxVersion: "TLS13"},
+			check: func(t *testing.T, c *tls.Config) {
+				if c.MinVersion != tls.VersionTLS11 || c.MaxVersion != tls.VersionTLS13 {
+					t.Fatalf("unexpected versions %x-%x", c.MinVersion, c.MaxVersion)
+				}
+			},
+		},
+		{
+			name: "unknown min version",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "SSL3"},
+			err:  true,
+		},
+		{
+			name: "unknown max version",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "TLS12", MaxVersion: "TLS14"},
+			err:  true,
+		},
+		{
+			name: "cipher suites",
+			cfg: tlsServerConfig{
+				CertFile: certFile, KeyFile: keyFile, MinVersion: "TLS12",
+				CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+			},
+			check: func(t *testing.T, c *tls.Config) {
+				want := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}
+				if len(c.CipherSuites) != len(want) || c.CipherSuites[0] != want[0] || c.CipherSuites[1] != want[1] {
+					t.Fatalf("expected cipher suites %v, got %v", want, c.CipherSuites)
+				}
+			},
+		},
+		{
+			name: "unknown cipher suite",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "TLS12", CipherSuites: []string{"TLS_NULL"}},
+			err:  true,
+		},
+		{
+			name: "curves",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "TLS12", CurvePreferences: []string{"X25519", "CurveP256"}},
+			check: func(t *testing.T, c *tls.Config) {
+				if len(c.CurvePreferences) != 2 || c.CurvePreferences[0] != tls.X25519 || c.CurvePreferences[1] != tls.CurveP256 {
+					t.Fatalf("unexpected curves %v", c.CurvePreferences)
+				}
+			},
+		},
+		{
+			name: "unknown curve",
+			cfg:  tlsServerConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "TLS12", CurvePreferences: []string{"CurveP224"}},
+			err:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := tc.cfg.config()
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.check != nil {
+				tc.check(t, c)
+			}
+		})
+	}
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "web.yml")
+	if err := ioutil.WriteFile(filename, []byte("tls_server_config:\n  cert_file: cert.pem\n  key_file: /etc/key.pem\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadWebConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TLSConfig.CertFile != filepath.Join(dir, "cert.pem") {
+		t.Fatalf("expected the relative path to be resolved, got %s", c.TLSConfig.CertFile)
+	}
+	if c.TLSConfig.KeyFile != "/etc/key.pem" {
+		t.Fatalf("expected the absolute path to be kept, got %s", c.TLSConfig.KeyFile)
+	}
+	if c.TLSConfig.MinVersion != "TLS12" || !c.HTTPConfig.HTTP2 {
+		t.Fatalf("unexpected defaults: %+v", c)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("basic_auth:\n  alice: x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadWebConfig(filename); err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+}
+
+func TestWebServerWrap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "web.yml")
+	cfg := "http_server_config:\n  headers:\n    X-Frame-Options: deny\nbasic_auth_users:\n  alice: " + mustHash(t, "secret") + "\n"
+	if err := ioutil.WriteFile(filename, []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newWebServer(filename, log.NewNopLogger())
+	h := s.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		name string
+		user string
+		pass string
+		auth bool
+		code int
+	}{
+		{name: "no credentials", code: http.StatusUnauthorized},
+		{name: "wrong password", user: "alice", pass: "wrong", auth: true, code: http.StatusUnauthorized},
+		{name: "unknown user", user: "bob", pass: "secret", auth: true, code: http.StatusUnauthorized},
+		{name: "valid credentials", user: "alice", pass: "secret", auth: true, code: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if tc.auth {
+				req.SetBasicAuth(tc.user, tc.pass)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.code {
+				t.Fatalf("expected status code %d, got %d", tc.code, rec.Code)
+			}
+			if got := rec.Header().Get("X-Frame-Options"); got != "deny" {
+				t.Fatalf("expected the configured header, got %q", got)
+			}
+			if tc.code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("expected the WWW-Authenticate header")
+			}
+		})
+	}
+}
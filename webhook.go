@@ -0,0 +1,202 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	webhookMinBackoff = time.Second
+	webhookMaxBackoff = time.Minute
+)
+
+var webhookNotifications = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "prometheus_scaleway_sd_webhook_notifications_total",
+		Help: "Total number of webhook notifications by result (success, failed or dropped).",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	reg.MustRegister(webhookNotifications)
+}
+
+// webhookPayload is the JSON document sent to the webhook.
+type webhookPayload struct {
+	Time     time.Time     `json:"time"`
+	Added    []targetEvent `json:"added"`
+	Removed  []targetEvent `json:"removed"`
+	Modified []targetEvent `json:"modified"`
+}
+
+// webhookNotifier POSTs the changes of the target groups to a webhook.
+//
+// Notifications are queued and sent in order by a single goroutine. Failed
+// requests are retried with an exponential backoff. When the queue is full,
+// the oldest notification is dropped.
+type webhookNotifier struct {
+	url        string
+	client     *http.Client
+	queue      chan *webhookPayload
+	maxRetries int
+	logger     log.Logger
+}
+
+func newWebhookNotifier(url string, queueSize, maxRetries int, timeout time.Duration, logger log.Logger) *webhookNotifier {
+	return &webhookNotifier{
+		url: url,
+		// The default transport is instrumented for the Scaleway API.
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConns:        1,
+				IdleConnTimeout:     90 * time.Second,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+		},
+		queue:      make(chan *webhookPayload, queueSize),
+		maxRetries: maxRetries,
+		logger:     log.With(logger, "component", "webhook"),
+	}
+}
+
+// Notify implements the Listener interface. It never blocks. The initial
+// snapshot of the targets after a start isn't notified.
+func (n *webhookNotifier) Notify(events []targetEvent) {
+	var changes []targetEvent
+	for _, e := range events {
+		if !e.Initial {
+			changes = append(changes, e)
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	p := &webhookPayload{
+		Time:     time.Now(),
+		Added:    []targetEvent{},
+		Removed:  []targetEvent{},
+		Modified: []targetEvent{},
+	}
+	for _, e := range changes {
+		switch e.Type {
+		case eventAdded:
+			p.Added = append(p.Added, e)
+		case eventRemoved:
+			p.Removed = append(p.Removed, e)
+		case eventChanged:
+			p.Modified = append(p.Modified, e)
+		}
+	}
+
+	for {
+		select {
+		case n.queue <- p:
+			return
+		default:
+		}
+		// The queue is full, drop the oldest notification to make room.
+		select {
+		case <-n.queue:
+			webhookNotifications.WithLabelValues("dropped").Inc()
+			level.Warn(n.logger).Log("msg", "queue full, dropping the oldest notification")
+		default:
+		}
+	}
+}
+
+// Run sends the queued notifications until the context is canceled.
+func (n *webhookNotifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-n.queue:
+			if err := n.sendWithRetries(ctx, p); err != nil {
+				webhookNotifications.WithLabelValues("failed").Inc()
+				level.Error(n.logger).Log("msg", "failed to send notification", "err", err)
+				continue
+			}
+			webhookNotifications.WithLabelValues("success").Inc()
+		}
+	}
+}
+
+func (n *webhookNotifier) sendWithRetries(ctx context.Context, p *webhookPayload) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	backoff := webhookMinBackoff
+	for i := 0; ; i++ {
+		retry, err := n.send(ctx, b)
+		if err == nil {
+			return nil
+		}
+		if !retry || i >= n.maxRetries {
+			return err
+		}
+		level.Debug(n.logger).Log("msg", "retrying notification", "err", err, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+// send POSTs the payload and returns whether the request should be retried on failure.
+func (n *webhookNotifier) send(ctx context.Context, b []byte) (bool, error) {
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Prometheus/SD-Agent")
+
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	// Client errors other than rate-limiting won't succeed on retry.
+	retry := resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+}